@@ -8,5 +8,10 @@ func getAdminRouter(cf *apiConfig) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Get("/metrics", cf.handlerViewHitCount)
+	r.Post("/search/reindex", cf.handleRebuildSearchIndex)
+	r.Get("/reports", cf.handleGetReportQueue)
+	r.Get("/reports/{id}", cf.handleGetReportCase)
+	r.Post("/reports/{id}/decision", cf.handleDecideReportCase)
+	r.Get("/audit", cf.handleGetModerationAudit)
 	return r
 }
\ No newline at end of file
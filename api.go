@@ -12,6 +12,18 @@ type apiConfig struct {
 	DB	*database.DB
 	jwtSecret string
 	polkaApiKey string
+	baseURL string
+	mailer Mailer
+	notifier Notifier
+	magicLinkEnabled bool
+	oidc *oidcProvider
+	sessionMode bool
+	secureCookies bool
+	allowedOrigins map[string]bool
+	contentFilter ContentFilter
+	moderators map[int]bool
+	blobStore BlobStore
+	maxMediaBytes int64
 }
 
 func (c *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -1,6 +1,10 @@
 package main
 
-import "github.com/go-chi/chi/v5"
+import (
+	"internal/database"
+
+	"github.com/go-chi/chi/v5"
+)
 
 func getApiRouter(cf *apiConfig) *chi.Mux {
 	r := chi.NewRouter()
@@ -12,13 +16,94 @@ func getApiRouter(cf *apiConfig) *chi.Mux {
 	r.Get("/chirps", cf.handleGetChirps)
 	r.Post("/chirps", cf.handlePostChirp)
 	r.Delete("/chirps/{id}", cf.handleDeleteChirp)
+	r.Put("/chirps/{id}", cf.handlePutChirp)
+	r.Patch("/chirps/{id}", cf.handlePutChirp)
+	r.Get("/chirps/{id}/history", cf.handleGetChirpHistory)
+	r.Get("/chirps/{id}/thread", cf.handleGetChirpThread)
+	r.Post("/chirps/{id}/like", cf.engagementHandler(cf.DB.LikeChirp))
+	r.Delete("/chirps/{id}/like", cf.engagementHandler(cf.DB.UnlikeChirp))
+	r.Post("/chirps/{id}/rechirp", cf.engagementHandler(cf.DB.Rechirp))
+	r.Delete("/chirps/{id}/rechirp", cf.engagementHandler(cf.DB.Unrechirp))
+	r.Post("/chirps/{id}/bookmark", cf.engagementHandler(cf.DB.Bookmark))
+	r.Delete("/chirps/{id}/bookmark", cf.engagementHandler(cf.DB.Unbookmark))
+	r.Post("/chirps/{id}/pin", cf.engagementHandler(cf.DB.PinChirp))
+	r.Delete("/chirps/{id}/pin", cf.engagementHandler(cf.DB.UnpinChirp))
+	r.Post("/chirps/{id}/poll/votes", cf.handleVotePoll)
+	r.Get("/drafts", cf.handleGetDrafts)
+	r.Post("/drafts", cf.handlePostDraft)
+	r.Get("/drafts/{id}", cf.handleGetDraft)
+	r.Put("/drafts/{id}", cf.handlePutDraft)
+	r.Delete("/drafts/{id}", cf.handleDeleteDraft)
+	r.Post("/drafts/{id}/publish", cf.handlePublishDraft)
+	r.Post("/media", cf.handlePostMedia)
+	r.Get("/media/{id}", cf.handleGetMedia)
+	r.Delete("/media/{id}", cf.handleDeleteMedia)
+	r.Get("/media/{id}/{variant}", cf.handleGetMediaFile)
+	r.Get("/search/chirps", cf.handleSearchChirps)
+	r.Get("/hashtags/{tag}/chirps", cf.handleGetHashtagChirps)
+	r.Get("/hashtags/{tag}/analytics", cf.handleGetHashtagAnalytics)
+	r.Get("/trends", cf.handleGetTrends)
 	// get chirps/id
 	r.Get("/users/{id}", cf.handleGetUser)
+	r.Get("/users/{id}/likes", cf.handleGetUserLikes)
+	r.Post("/users/{id}/follow", cf.handleFollow)
+	r.Delete("/users/{id}/follow", cf.handleUnfollow)
+	r.Get("/users/{id}/followers", cf.followListHandler(cf.DB.ListFollowers, func(counts database.FollowCounts) int {
+		return counts.Followers
+	}))
+	r.Get("/users/{id}/following", cf.followListHandler(cf.DB.ListFollowing, func(counts database.FollowCounts) int {
+		return counts.Following
+	}))
+	r.Post("/users/{id}/block", cf.relationHandler(cf.DB.Block, "User blocked"))
+	r.Delete("/users/{id}/block", cf.relationHandler(cf.DB.Unblock, "User unblocked"))
+	r.Post("/users/{id}/mute", cf.relationHandler(cf.DB.Mute, "User muted"))
+	r.Delete("/users/{id}/mute", cf.relationHandler(cf.DB.Unmute, "User unmuted"))
+	r.Get("/timeline", cf.handleGetTimeline)
 	r.Get("/users", cf.handleGetUsers)
 	r.Post("/users", cf.handlePostUsers)
 	r.Put("/users", cf.handlePutUser)
+	r.Put("/users/me/profile", cf.handlePutProfile)
+	r.Get("/users/me/identities", cf.handleGetIdentities)
+	r.Delete("/users/me/identities/{id}", cf.handleDeleteIdentity)
+	r.Get("/users/me/security-events", cf.handleGetSecurityEvents)
+	r.Get("/users/me/mentions", cf.handleGetMentions)
+	r.Get("/users/me/mentions/unread_count", cf.handleGetUnreadMentionCount)
+	r.Post("/users/me/mentions/read", cf.handleMarkMentionsRead)
+	r.Get("/users/me/blocks", cf.handleGetBlocks)
+	r.Get("/users/me/mutes", cf.handleGetMutes)
+	r.Get("/users/me/filters", cf.handleGetFilters)
+	r.Post("/users/me/filters", cf.handlePostFilter)
+	r.Delete("/users/me/filters/{id}", cf.handleDeleteFilter)
+	r.Get("/users/me/moderation", cf.handleGetMyModerationCases)
+	r.Post("/users/me/moderation/{id}/appeal", cf.handleAppealModerationCase)
+	r.Post("/reports", cf.handlePostReport)
+	r.Get("/users/me/bookmarks", cf.handleGetBookmarks)
+	r.Get("/users/{id}/lists", cf.handleGetUserLists)
+	r.Get("/lists", cf.handleGetMyLists)
+	r.Post("/lists", cf.handlePostList)
+	r.Get("/lists/{id}", cf.handleGetList)
+	r.Put("/lists/{id}", cf.handlePutList)
+	r.Delete("/lists/{id}", cf.handleDeleteList)
+	r.Get("/lists/{id}/members", cf.handleGetListMembers)
+	r.Post("/lists/{id}/members", cf.listMembersHandler(cf.DB.AddListMembers))
+	r.Delete("/lists/{id}/members", cf.listMembersHandler(cf.DB.RemoveListMembers))
+	r.Get("/lists/{id}/chirps", cf.handleGetListChirps)
+	r.Put("/users/me/messaging", cf.handlePutMessagingSettings)
+	r.Get("/conversations", cf.handleGetConversations)
+	r.Post("/conversations", cf.handlePostConversation)
+	r.Get("/conversations/unread_count", cf.handleGetUnreadMessageCount)
+	r.Get("/conversations/{id}", cf.handleGetConversation)
+	r.Delete("/conversations/{id}", cf.handleDeleteConversation)
+	r.Get("/conversations/{id}/messages", cf.handleGetMessages)
+	r.Post("/conversations/{id}/messages", cf.handlePostMessage)
+	r.Post("/conversations/{id}/read", cf.handleMarkConversationRead)
 
 	r.Post("/login", cf.handleLogin)
+	r.Post("/login/magic", cf.handleMagicLinkRequest)
+	r.Get("/login/magic/consume", cf.handleMagicLinkConfirm)
+	r.Post("/login/magic/consume", cf.handleMagicLinkConsume)
+	r.Get("/login/oidc", cf.handleOIDCLogin)
+	r.Get("/login/oidc/callback", cf.handleOIDCCallback)
 	r.Post("/refresh", cf.handleRefreshToken)
 	r.Post("/revoke", cf.handleRevokeToken)
 
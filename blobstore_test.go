@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockS3 is an S3-compatible server that keeps objects in memory. It checks
+// every request's Signature V4 against its own secret key, computed here
+// independently of s3BlobStore.sign.
+type mockS3 struct {
+	server    *httptest.Server
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMockS3(t *testing.T) *mockS3 {
+	t.Helper()
+	m := &mockS3{accessKey: "test-access", secretKey: "test-secret", region: "eu-west-1", objects: map[string][]byte{}}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockS3) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !m.validSignature(r, body) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	key := r.URL.EscapedPath()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		m.objects[key] = body
+	case http.MethodGet:
+		data, ok := m.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		// S3 answers 204 whether or not the object existed
+		delete(m.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *mockS3) validSignature(r *http.Request, body []byte) bool {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+		return false
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return false
+	}
+	date := amzDate[:8]
+
+	canonicalRequest := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" +
+		payloadHash
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + m.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + m.secretKey)
+	for _, part := range []string{date, m.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=" + m.accessKey + "/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + hex.EncodeToString(key)
+	return hmac.Equal([]byte(r.Header.Get("Authorization")), []byte(want))
+}
+
+func TestS3BlobStore(t *testing.T) {
+	m := newMockS3(t)
+	store := s3BlobStore{
+		endpoint:  m.server.URL,
+		bucket:    "media",
+		region:    m.region,
+		accessKey: m.accessKey,
+		secretKey: m.secretKey,
+		client:    m.server.Client(),
+	}
+	wrongKey := store
+	wrongKey.secretKey = "not-the-secret"
+
+	get := func(s s3BlobStore, key string) (string, error) {
+		body, err := s.Get(key)
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		return string(data), err
+	}
+
+	tests := []struct {
+		name    string
+		run     func() (string, error)
+		want    string
+		wantErr error
+		// wantAnyErr is for failures that have no sentinel error
+		wantAnyErr bool
+	}{
+		{
+			name: "put then get",
+			run: func() (string, error) {
+				if err := store.Put("1/original.jpg", "image/jpeg", []byte("jpeg bytes")); err != nil {
+					return "", err
+				}
+				return get(store, "1/original.jpg")
+			},
+			want: "jpeg bytes",
+		},
+		{
+			name: "keys are escaped",
+			run: func() (string, error) {
+				if err := store.Put("2/a photo+1.png", "image/png", []byte("png bytes")); err != nil {
+					return "", err
+				}
+				return get(store, "2/a photo+1.png")
+			},
+			want: "png bytes",
+		},
+		{
+			name:    "get missing",
+			run:     func() (string, error) { return get(store, "missing.jpg") },
+			wantErr: errBlobNotFound,
+		},
+		{
+			name: "delete then get",
+			run: func() (string, error) {
+				if err := store.Put("3/original.gif", "image/gif", []byte("gif bytes")); err != nil {
+					return "", err
+				}
+				if err := store.Delete("3/original.gif"); err != nil {
+					return "", err
+				}
+				return get(store, "3/original.gif")
+			},
+			wantErr: errBlobNotFound,
+		},
+		{
+			name: "delete missing",
+			run:  func() (string, error) { return "", store.Delete("missing.jpg") },
+		},
+		{
+			name:       "wrong secret is refused",
+			run:        func() (string, error) { return "", wrongKey.Put("4/original.jpg", "image/jpeg", []byte("x")) },
+			wantAnyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.run()
+			switch {
+			case tt.wantAnyErr:
+				if err == nil || errors.Is(err, errBlobNotFound) {
+					t.Fatalf("err = %v, want a request error", err)
+				}
+				if !strings.Contains(err.Error(), "403") {
+					t.Errorf("err = %v, want a 403", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("err = %v", err)
+			case got != tt.want:
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// relationHandler builds a handler that applies a block, mute or their undo
+// from the authenticated user to the user in the path.
+func (c *apiConfig) relationHandler(action func(fromID, toID int) error, done string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		err = action(tokenClaims.Id, id)
+		if err != nil {
+			switch err.Error() {
+			case "user not found":
+				respondWithError(w, http.StatusNotFound, "User not found")
+			case "cannot block yourself", "cannot mute yourself":
+				respondWithError(w, http.StatusBadRequest, err.Error())
+			default:
+				log.Printf("Error updating user relation %s", err)
+				respondWithError(w, http.StatusInternalServerError, "Error updating user")
+			}
+			return
+		}
+		respondWithJSON(w, http.StatusOK, done)
+	}
+}
+
+func (c *apiConfig) handleGetBlocks(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListBlocked(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting blocks %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting blocks")
+		return
+	}
+	users, err := c.presentUsers(tokenClaims.Id, page.Users)
+	if err != nil {
+		log.Printf("Error presenting blocks %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting blocks")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (c *apiConfig) handleGetMutes(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListMuted(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting mutes %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting mutes")
+		return
+	}
+	users, err := c.presentUsers(tokenClaims.Id, page.Users)
+	if err != nil {
+		log.Printf("Error presenting mutes %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting mutes")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, users)
+}
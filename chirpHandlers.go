@@ -2,13 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"internal/database"
 	"io"
 	"log"
 	"net/http"
-	"sort"
 	"strconv"
-	"strings"
+	"time"
 )
 func (c *apiConfig) handlePostChirp(w http.ResponseWriter, r *http.Request){
 	// get token
@@ -22,11 +23,13 @@ func (c *apiConfig) handlePostChirp(w http.ResponseWriter, r *http.Request){
 	defer r.Body.Close()
 	type requestBody struct {
 		Body string `json:"body"`
-	}
-	type returnBody struct {
-		Id int `json:"id"`
-		Cleaned_body string `json:"body"`
-		Author int `json:"author_id"`
+		InReplyToId int `json:"in_reply_to_id"`
+		QuoteOfId int `json:"quote_of_id"`
+		Visibility string `json:"visibility"`
+		Attachments []attachmentRequest `json:"attachments"`
+		Poll *database.NewPoll `json:"poll"`
+		PublishAt *time.Time `json:"publish_at"`
+		Draft bool `json:"draft"`
 	}
 	dat, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -41,25 +44,66 @@ func (c *apiConfig) handlePostChirp(w http.ResponseWriter, r *http.Request){
 		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
 		return	
 	}
-	if len(rBody.Body) > 140 {
-		log.Printf("Chirp too long")
-		respondWithError(w, http.StatusBadRequest, "Chirp too long")
+	content, err := c.cleanChirpBody(tokenClaims.Id, rBody.Body)
+	if err != nil {
+		respondWithCleanChirpError(w, content, err)
 		return
 	}
-	badwords := []string{"kerfuffle", "sharbert", "fornax"}
-	// clean the input of bad words case insensitive
-	cleaned := rBody.Body
-	for _, word := range strings.Split(cleaned, " ") {
-		for _, badword := range badwords {
-			if strings.ToLower(word) == badword {
-				cleaned = strings.ReplaceAll(cleaned, word, "****")
-			}
-		}
+	poll, pollContent, err := c.cleanPoll(rBody.Poll)
+	if err != nil {
+		respondWithCleanChirpError(w, pollContent, err)
+		return
 	}
 
-	// save to file database.json
-	chirp, err := c.DB.CreateChirp(cleaned, tokenClaims.Id)
+	// drafts and scheduled chirps are kept aside until they're published
+	if rBody.Draft || rBody.PublishAt != nil {
+		c.createDraft(w, database.Draft{
+			Author: tokenClaims.Id,
+			Body: content.Text,
+			InReplyToID: rBody.InReplyToId,
+			QuoteOfID: rBody.QuoteOfId,
+			Visibility: rBody.Visibility,
+			Attachments: toAttachments(rBody.Attachments),
+			Poll: poll,
+			PublishAt: rBody.PublishAt,
+			Flags: content.flags(),
+		}, content)
+		return
+	}
+
+	// save to file database.json; CreateChirp checks the reply target in the
+	// same transaction
+	chirp, err := c.DB.CreateChirp(content.Text, tokenClaims.Id, database.ChirpOptions{
+		InReplyToID: rBody.InReplyToId,
+		QuoteOfID: rBody.QuoteOfId,
+		Visibility: rBody.Visibility,
+		Attachments: toAttachments(rBody.Attachments),
+		Poll: poll,
+	})
 	if err != nil {
+		if respondWithAttachmentError(w, err) || respondWithPollError(w, err) {
+			return
+		}
+		if err.Error() == "invalid visibility" {
+			respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted, followers or direct")
+			return
+		}
+		if err.Error() == "parent chirp not found" {
+			respondWithError(w, http.StatusBadRequest, "Chirp being replied to does not exist")
+			return
+		}
+		if err.Error() == "quoted chirp not found" {
+			respondWithError(w, http.StatusBadRequest, "Chirp being quoted does not exist")
+			return
+		}
+		if err.Error() == "blocked" {
+			respondWithError(w, http.StatusForbidden, "Cannot interact with this user")
+			return
+		}
+		if err.Error() == "posting disabled" {
+			respondWithError(w, http.StatusForbidden, "Posting has been disabled on your account")
+			return
+		}
 		log.Printf("Error creating chirp %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Error creating chirp")
 		return
@@ -67,49 +111,86 @@ func (c *apiConfig) handlePostChirp(w http.ResponseWriter, r *http.Request){
 
 
 	// respond with id and cleaned body
-	respondWithJSON(w, http.StatusCreated, returnBody{
-		Id: chirp.ID,
-		Cleaned_body: chirp.Body,
-		Author: chirp.Author,
-	})
+	c.respondWithModeratedChirp(w, http.StatusCreated, tokenClaims.Id, chirp, content)
+}
+
+// cleanChirpBody checks the chirp against its author's length limit and runs
+// it through content moderation, which may censor it.
+func (c *apiConfig) cleanChirpBody(authorID int, body string) (moderatedContent, error) {
+	author, err := c.DB.GetUser(strconv.Itoa(authorID))
+	if err != nil {
+		return moderatedContent{}, err
+	}
+	length, limit := chirpLength(body), chirpLimitFor(author)
+	if length > limit {
+		return moderatedContent{}, chirpLengthError{Length: length, Limit: limit}
+	}
+	content := c.moderate(contentChirp, body)
+	if content.rejected() {
+		return content, errors.New("chirp rejected")
+	}
+	return content, nil
+}
+
+// respondWithCleanChirpError reports why cleanChirpBody refused a chirp.
+func respondWithCleanChirpError(w http.ResponseWriter, content moderatedContent, err error) {
+	if err.Error() == "chirp rejected" {
+		hit := content.firstHit(moderationReject)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp rejected by %s rule %q", hit.Stage, hit.Rule))
+		return
+	}
+	var lengthErr chirpLengthError
+	if errors.As(err, &lengthErr) {
+		respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  fmt.Sprintf("Chirp too long: %d characters, the limit is %d", lengthErr.Length, lengthErr.Limit),
+			"length": lengthErr.Length,
+			"limit":  lengthErr.Limit,
+		})
+		return
+	}
+	if err.Error() == "user not found" {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	log.Printf("Error checking chirp %s", err)
+	respondWithError(w, http.StatusInternalServerError, "Error checking chirp")
+}
+
+// respondWithModeratedChirp records whether moderation flagged the chirp for
+// review and shows its author which moderation rules fired.
+func (c *apiConfig) respondWithModeratedChirp(w http.ResponseWriter, code int, viewerID int, chirp database.Chirp, content moderatedContent) {
+	err := c.DB.FlagChirp(chirp.ID, content.flags())
+	if err != nil {
+		log.Printf("Error flagging chirp %s", err)
+	}
+
+	response, err := c.presentChirp(viewerID, chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirp")
+		return
+	}
+	response.Moderation = content.Hits
+	respondWithJSON(w, code, response)
 }
 
 func (c *apiConfig) handleGetChirps(w http.ResponseWriter, r *http.Request){
-	// get from database
-	dbChirps, err := c.DB.GetChirps()
-	
-	authorId := r.URL.Query().Get("author_id")
-	sortQuery := r.URL.Query().Get("sort")
+	chirpQuery, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chirpQuery.ViewerID = c.optionalViewer(r)
 
+	// get from database
+	page, err := c.DB.QueryChirps(chirpQuery)
 	if err != nil {
 		log.Printf("Error getting chirps %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Error getting chirps")
 		return
 	}
-	chirps := []database.Chirp{}
-	for _, chirp := range dbChirps {
-		if authorId != "" {
-			if strconv.Itoa(chirp.Author) != authorId {
-				continue
-			}
-		}
-		chirps = append(chirps, database.Chirp{
-			ID: chirp.ID,
-			Body: chirp.Body,
-			Author: chirp.Author,
-		})
-	}
-	if sortQuery == "asc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID < chirps[j].ID
-		})
-	} else if sortQuery == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID > chirps[j].ID
-		})
-	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, chirpQuery.ViewerID, page.Chirps)
 }
 
 func (c *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request){
@@ -125,7 +206,20 @@ func (c *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request){
 		respondWithError(w, http.StatusInternalServerError, "Error getting chirp")
 		return
 	}
-	respondWithJSON(w, http.StatusOK, dbChirp)
+	// chirps the viewer can't read are reported as missing so their
+	// existence isn't revealed
+	viewerID := c.optionalViewer(r)
+	visible, err := c.DB.CanView(viewerID, dbChirp)
+	if err != nil {
+		log.Printf("Error getting chirp %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirp")
+		return
+	}
+	if !visible {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	c.respondWithChirp(w, http.StatusOK, viewerID, dbChirp)
 }
 
 func (c *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request){
@@ -158,4 +252,139 @@ func (c *apiConfig) handleDeleteChirp(w http.ResponseWriter, r *http.Request){
 	}
 	respondWithJSON(w, http.StatusOK, "Chirp deleted")
 
-}
\ No newline at end of file
+}
+
+func (c *apiConfig) handlePutChirp(w http.ResponseWriter, r *http.Request){
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Body string `json:"body"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	content, err := c.cleanChirpBody(tokenClaims.Id, rBody.Body)
+	if err != nil {
+		respondWithCleanChirpError(w, content, err)
+		return
+	}
+
+	chirp, err := c.DB.UpdateChirp(id, tokenClaims.Id, content.Text)
+	if err != nil {
+		if err.Error() == "chirp not found" {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		if err.Error() == "unauthorized" {
+			respondWithError(w, http.StatusForbidden, "Unauthorized")
+			return
+		}
+		if err.Error() == "posting disabled" {
+			respondWithError(w, http.StatusForbidden, "Posting has been disabled on your account")
+			return
+		}
+		log.Printf("Error updating chirp %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating chirp")
+		return
+	}
+	c.respondWithModeratedChirp(w, http.StatusOK, tokenClaims.Id, chirp, content)
+}
+
+func (c *apiConfig) handleGetChirpHistory(w http.ResponseWriter, r *http.Request){
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	versions, err := c.DB.GetChirpHistory(id, c.optionalViewer(r))
+	if err != nil {
+		if err.Error() == "chirp not found" {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		log.Printf("Error getting chirp history %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirp history")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+func (c *apiConfig) handleGetChirpThread(w http.ResponseWriter, r *http.Request){
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	type threadChirp struct {
+		chirpResponse
+		Depth int `json:"depth"`
+	}
+	type returnBody struct {
+		Ancestors []chirpResponse `json:"ancestors"`
+		Chirp chirpResponse `json:"chirp"`
+		Descendants []threadChirp `json:"descendants"`
+	}
+
+	viewerID := c.optionalViewer(r)
+	thread, err := c.DB.GetThread(id, viewerID, pageQuery)
+	if err != nil {
+		if err.Error() == "chirp not found" {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		log.Printf("Error getting thread %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting thread")
+		return
+	}
+
+	// present the whole thread at once, ancestors first, then the chirp,
+	// then its descendants
+	chirps := append([]database.Chirp{}, thread.Ancestors...)
+	chirps = append(chirps, thread.Chirp)
+	for _, descendant := range thread.Descendants {
+		chirps = append(chirps, descendant.Chirp)
+	}
+	presented, err := c.presentChirps(viewerID, chirps)
+	if err != nil {
+		log.Printf("Error getting thread %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting thread")
+		return
+	}
+	n := len(thread.Ancestors)
+	descendants := make([]threadChirp, 0, len(thread.Descendants))
+	for i, descendant := range thread.Descendants {
+		descendants = append(descendants, threadChirp{chirpResponse: presented[n+1+i], Depth: descendant.Depth})
+	}
+
+	setPageHeaders(w, r, thread.Next, thread.Prev)
+	respondWithJSON(w, http.StatusOK, returnBody{
+		Ancestors: presented[:n],
+		Chirp: presented[n],
+		Descendants: descendants,
+	})
+}
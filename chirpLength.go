@@ -0,0 +1,138 @@
+package main
+
+import (
+	"internal/database"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// chirpLimit is the longest chirp a regular user can post.
+	chirpLimit = 140
+	// chirpyRedChirpLimit is the longest chirp a Chirpy Red user can post.
+	chirpyRedChirpLimit = 280
+	// urlWeight is how many characters a link counts for, however long it
+	// is, so shortened and full links cost the same.
+	urlWeight = 23
+)
+
+// chirpLengthError is returned for chirps over their author's limit. Its
+// message stays "chirp too long" so callers can keep comparing on it.
+type chirpLengthError struct {
+	Length int
+	Limit  int
+}
+
+func (e chirpLengthError) Error() string {
+	return "chirp too long"
+}
+
+// chirpLimitFor returns the longest chirp user can post.
+func chirpLimitFor(user database.User) int {
+	if user.IsChirpyRed {
+		return chirpyRedChirpLimit
+	}
+	return chirpLimit
+}
+
+// chirpLength counts body the way users see it: each grapheme cluster is one
+// character, so an emoji or an accented letter made of several code points
+// counts once, and each link counts for urlWeight characters.
+func chirpLength(body string) int {
+	length := 0
+	last := 0
+	for _, loc := range database.LinkPattern.FindAllStringIndex(body, -1) {
+		length += graphemeCount(body[last:loc[0]]) + urlWeight
+		last = loc[1]
+	}
+	return length + graphemeCount(body[last:])
+}
+
+// graphemeCount counts the extended grapheme clusters of s. It follows the
+// rules of UAX #29 that matter for chirps: combining marks, variation
+// selectors, emoji modifiers and tags extend the cluster before them, a
+// zero width joiner glues emoji together, regional indicators pair up into
+// flags, Hangul jamo combine into syllables and CRLF is one break.
+func graphemeCount(s string) int {
+	count := 0
+	prev := rune(-1)
+	regionalRun := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if prev >= 0 && !graphemeBreak(prev, r, regionalRun) {
+			if isRegionalIndicator(r) {
+				regionalRun++
+			}
+			prev = r
+			continue
+		}
+		count++
+		regionalRun = 0
+		if isRegionalIndicator(r) {
+			regionalRun = 1
+		}
+		prev = r
+	}
+	return count
+}
+
+// graphemeBreak reports whether a cluster boundary falls between prev and r.
+// regionalRun is the number of regional indicators at the end of the
+// current cluster.
+func graphemeBreak(prev, r rune, regionalRun int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return false
+	case prev == '\r' || prev == '\n' || r == '\r' || r == '\n':
+		return true
+	case isGraphemeExtend(r) || r == zeroWidthJoiner:
+		return false
+	case prev == zeroWidthJoiner && isPictographic(r):
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regionalRun%2 == 0
+	case hangulJoins(prev, r):
+		return false
+	}
+	return true
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		(r >= 0xfe00 && r <= 0xfe0f) ||
+		(r >= 0x1f3fb && r <= 0x1f3ff) ||
+		(r >= 0xe0020 && r <= 0xe007f)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+func isPictographic(r rune) bool {
+	return (r >= 0x1f000 && r <= 0x1faff) || (r >= 0x2600 && r <= 0x27bf) || unicode.Is(unicode.So, r)
+}
+
+// hangulJoins reports whether the Hangul jamo or syllable r continues the
+// syllable ending in prev.
+func hangulJoins(prev, r rune) bool {
+	lead := func(r rune) bool { return r >= 0x1100 && r <= 0x115f }
+	vowel := func(r rune) bool { return r >= 0x1160 && r <= 0x11a7 }
+	trail := func(r rune) bool { return r >= 0x11a8 && r <= 0x11ff }
+	syllable := func(r rune) bool { return r >= 0xac00 && r <= 0xd7a3 }
+	// Syllables without a final consonant (LV) can still take more vowel
+	// or trailing jamo; those with one (LVT) only trailing jamo.
+	lv := func(r rune) bool { return syllable(r) && (r-0xac00)%28 == 0 }
+
+	switch {
+	case lead(prev):
+		return lead(r) || vowel(r) || syllable(r)
+	case vowel(prev) || lv(prev):
+		return vowel(r) || trail(r)
+	case trail(prev) || syllable(prev):
+		return trail(r)
+	}
+	return false
+}
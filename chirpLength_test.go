@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "ascii", text: "hello", want: 5},
+		{name: "precomposed accent", text: "café", want: 4},
+		{name: "combining accent", text: "cafe\u0301", want: 4},
+		{name: "stacked combining marks", text: "a\u0301\u0323\u0308b", want: 2},
+		{name: "variation selector", text: "\u2764\ufe0f", want: 1},
+		{name: "skin tone modifier", text: "\U0001f44d\U0001f3fd", want: 1},
+		{name: "zwj family", text: "\U0001f468\u200d\U0001f469\u200d\U0001f467\u200d\U0001f466", want: 1},
+		{name: "zwj with modifiers", text: "\U0001f469\U0001f3fd\u200d\U0001f4bb!", want: 2},
+		{name: "flag", text: "\U0001f1e6\U0001f1f7", want: 1},
+		{name: "two flags", text: "\U0001f1e6\U0001f1f7\U0001f1e7\U0001f1f7", want: 2},
+		{name: "odd regional indicator", text: "\U0001f1e6\U0001f1f7\U0001f1e7", want: 2},
+		{name: "tag sequence flag", text: "\U0001f3f4\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f", want: 1},
+		{name: "hangul syllables", text: "한국어", want: 3},
+		{name: "hangul jamo", text: "\u1112\u1161\u11ab", want: 1},
+		{name: "hangul jamo syllables", text: "\u1112\u1161\u1100\u1173\u11af", want: 2},
+		{name: "hangul syllable and trailing jamo", text: "\ud558\u11ab", want: 1},
+		{name: "crlf", text: "a\r\nb", want: 3},
+		{name: "mark after newline", text: "\n\u0301", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := graphemeCount(tt.text); got != tt.want {
+				t.Errorf("graphemeCount(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirpLength(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "no links", body: "hello world", want: 11},
+		{name: "short link", body: "http://a.io", want: urlWeight},
+		{name: "long link", body: "see https://example.com/" + strings.Repeat("a", 200), want: 4 + urlWeight},
+		{name: "links between text", body: "a http://a.io b HTTPS://b.io c", want: 7 + 2*urlWeight},
+		{name: "emoji next to a link", body: "\U0001f44d\U0001f3fd https://example.com", want: 2 + urlWeight},
+		{name: "bare domain isn't a link", body: "example.com", want: 11},
+		{name: "scheme inside a word isn't a link", body: "xhttp://a.io", want: 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chirpLength(tt.body); got != tt.want {
+				t.Errorf("chirpLength(%q) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChirpLengthLimits(t *testing.T) {
+	c := newTestConfig(t)
+	regular, err := c.DB.CreateUser("regular@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	red, err := c.DB.CreateUser("red@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := c.DB.UpgradeUserToChirpyRed(red.ID); err != nil {
+		t.Fatalf("UpgradeUserToChirpyRed: %v", err)
+	}
+	link := "https://example.com/" + strings.Repeat("x", 100)
+
+	tests := []struct {
+		name     string
+		authorID int
+		body     string
+		// wantLength is the length reported when the chirp is too long
+		wantLength int
+		wantLimit  int
+	}{
+		{name: "regular at the limit", authorID: regular.ID, body: strings.Repeat("a", chirpLimit)},
+		{name: "regular over the limit", authorID: regular.ID, body: strings.Repeat("a", chirpLimit+1), wantLength: chirpLimit + 1, wantLimit: chirpLimit},
+		{name: "regular emoji at the limit", authorID: regular.ID, body: strings.Repeat("\U0001f44d\U0001f3fd", chirpLimit)},
+		{name: "regular accents at the limit", authorID: regular.ID, body: strings.Repeat("é", chirpLimit)},
+		{name: "regular flags over the limit", authorID: regular.ID, body: strings.Repeat("\U0001f1e6\U0001f1f7", chirpLimit+1), wantLength: chirpLimit + 1, wantLimit: chirpLimit},
+		{name: "regular long link at the limit", authorID: regular.ID, body: strings.Repeat("a", chirpLimit-urlWeight-1) + " " + link},
+		{name: "regular long link over the limit", authorID: regular.ID, body: strings.Repeat("a", chirpLimit-urlWeight) + " " + link, wantLength: chirpLimit + 1, wantLimit: chirpLimit},
+		{name: "chirpy red over the regular limit", authorID: red.ID, body: strings.Repeat("a", chirpLimit+1)},
+		{name: "chirpy red at the limit", authorID: red.ID, body: strings.Repeat("a", chirpyRedChirpLimit)},
+		{name: "chirpy red over the limit", authorID: red.ID, body: strings.Repeat("a", chirpyRedChirpLimit+1), wantLength: chirpyRedChirpLimit + 1, wantLimit: chirpyRedChirpLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.cleanChirpBody(tt.authorID, tt.body)
+			if tt.wantLimit == 0 {
+				if err != nil {
+					t.Fatalf("cleanChirpBody: %v", err)
+				}
+				return
+			}
+			lengthErr, ok := err.(chirpLengthError)
+			if !ok {
+				t.Fatalf("err = %v, want a chirpLengthError", err)
+			}
+			if lengthErr.Length != tt.wantLength || lengthErr.Limit != tt.wantLimit {
+				t.Errorf("length %d of %d, want %d of %d", lengthErr.Length, lengthErr.Limit, tt.wantLength, tt.wantLimit)
+			}
+		})
+	}
+}
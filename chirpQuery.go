@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"internal/database"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var chirpQueryParams = map[string]bool{
+	"author_id": true,
+	"since":     true,
+	"until":     true,
+	"since_id":  true,
+	"max_id":    true,
+	"contains":  true,
+	"has":       true,
+	"order_by":  true,
+	"sort":      true,
+	"cursor":    true,
+	"limit":     true,
+}
+
+// parseChirpQuery turns the query string of GET /api/chirps into a database
+// query. Unknown or malformed parameters are reported back to the client.
+func parseChirpQuery(r *http.Request) (database.ChirpQuery, error) {
+	query := r.URL.Query()
+	q := database.ChirpQuery{OrderBy: database.OrderByID}
+
+	unknown := []string{}
+	for param := range query {
+		if !chirpQueryParams[param] {
+			unknown = append(unknown, param)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return q, fmt.Errorf("unknown query parameter(s): %s", strings.Join(unknown, ", "))
+	}
+
+	for _, value := range query["author_id"] {
+		for _, author := range strings.Split(value, ",") {
+			authorID, err := strconv.Atoi(strings.TrimSpace(author))
+			if err != nil || authorID < 1 {
+				return q, fmt.Errorf("author_id must be a comma-separated list of user ids, got %q", value)
+			}
+			q.AuthorIDs = append(q.AuthorIDs, authorID)
+		}
+	}
+
+	var err error
+	q.Since, err = parseTimeParam(query, "since")
+	if err != nil {
+		return q, err
+	}
+	q.Until, err = parseTimeParam(query, "until")
+	if err != nil {
+		return q, err
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && !q.Since.Before(q.Until) {
+		return q, fmt.Errorf("since must be before until")
+	}
+	q.SinceID, err = parseIDParam(query, "since_id")
+	if err != nil {
+		return q, err
+	}
+	q.MaxID, err = parseIDParam(query, "max_id")
+	if err != nil {
+		return q, err
+	}
+
+	q.Contains = query.Get("contains")
+
+	if has := query.Get("has"); has != "" {
+		for _, value := range strings.Split(has, ",") {
+			switch strings.TrimSpace(value) {
+			case "mention":
+				q.HasMention = true
+			case "link":
+				q.HasLink = true
+			default:
+				return q, fmt.Errorf("has must be a comma-separated list of mention and link, got %q", value)
+			}
+		}
+	}
+
+	switch orderBy := query.Get("order_by"); orderBy {
+	case "":
+	case database.OrderByID, database.OrderByCreatedAt, database.OrderByEngagement:
+		q.OrderBy = orderBy
+	default:
+		return q, fmt.Errorf("order_by must be one of id, created_at or engagement, got %q", orderBy)
+	}
+
+	q.Page, err = parsePageQuery(r)
+	if err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func parseTimeParam(query url.Values, name string) (time.Time, error) {
+	values := query[name]
+	if len(values) == 0 || values[0] == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, values[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp such as 2024-01-02T15:04:05Z, got %q", name, values[0])
+	}
+	return t, nil
+}
+
+func parseIDParam(query url.Values, name string) (int, error) {
+	values := query[name]
+	if len(values) == 0 || values[0] == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(values[0])
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%s must be a positive chirp id, got %q", name, values[0])
+	}
+	return id, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"internal/database"
+	"net/http"
+)
+
+// chirpResponse is a chirp as seen by a particular viewer. Filtered lists
+// the viewer's keyword filters the chirp matched, so clients can collapse it.
+// Moderation is only shown to the author right after posting or editing.
+type chirpResponse struct {
+	database.Chirp
+	LikedByMe      bool                     `json:"liked_by_me"`
+	RechirpedByMe  bool                     `json:"rechirped_by_me"`
+	BookmarkedByMe bool                     `json:"bookmarked_by_me"`
+	Filtered       []database.FilterMatch   `json:"filtered,omitempty"`
+	Moderation     []database.ModerationHit `json:"moderation,omitempty"`
+	// Attachments replaces the chirp's own attachments, adding their URLs.
+	Attachments []attachmentResponse `json:"attachments,omitempty"`
+	// Poll replaces the chirp's own poll, hiding results the viewer can't
+	// see yet.
+	Poll *pollResponse `json:"poll,omitempty"`
+}
+
+// optionalViewer returns the ID of the user making the request, or 0 when
+// the request isn't authenticated. Public endpoints use it to personalize
+// their responses without requiring a login.
+func (c *apiConfig) optionalViewer(r *http.Request) int {
+	if requestToken(r, accessCookieName) == "" {
+		return 0
+	}
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		return 0
+	}
+	return tokenClaims.Id
+}
+
+// presentChirps adds the viewer's own state to each chirp.
+func (c *apiConfig) presentChirps(viewerID int, chirps []database.Chirp) ([]chirpResponse, error) {
+	responses := make([]chirpResponse, 0, len(chirps))
+	states := map[int]database.ViewerState{}
+	if viewerID != 0 {
+		ids := make([]int, 0, len(chirps))
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.ID)
+		}
+		var err error
+		states, err = c.DB.GetViewerStates(viewerID, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, chirp := range chirps {
+		responses = append(responses, chirpResponse{
+			Chirp:          chirp,
+			LikedByMe:      states[chirp.ID].Liked,
+			RechirpedByMe:  states[chirp.ID].Rechirped,
+			BookmarkedByMe: states[chirp.ID].Bookmarked,
+			Filtered:       states[chirp.ID].Filtered,
+			Attachments:    c.presentAttachments(chirp.Attachments),
+			Poll:           presentPoll(chirp.Poll, states[chirp.ID].PollVote),
+		})
+	}
+	return responses, nil
+}
+
+func (c *apiConfig) presentChirp(viewerID int, chirp database.Chirp) (chirpResponse, error) {
+	responses, err := c.presentChirps(viewerID, []database.Chirp{chirp})
+	if err != nil {
+		return chirpResponse{}, err
+	}
+	return responses[0], nil
+}
+
+// respondWithChirps presents chirps for the viewer and writes them out.
+func (c *apiConfig) respondWithChirps(w http.ResponseWriter, code int, viewerID int, chirps []database.Chirp) {
+	responses, err := c.presentChirps(viewerID, chirps)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirps")
+		return
+	}
+	respondWithJSON(w, code, responses)
+}
+
+func (c *apiConfig) respondWithChirp(w http.ResponseWriter, code int, viewerID int, chirp database.Chirp) {
+	response, err := c.presentChirp(viewerID, chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirp")
+		return
+	}
+	respondWithJSON(w, code, response)
+}
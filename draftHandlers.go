@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// draftResponse is a draft as shown to its author. Like a chirp, it carries
+// the moderation rules that fired when it was saved.
+type draftResponse struct {
+	database.Draft
+	Moderation []database.ModerationHit `json:"moderation,omitempty"`
+}
+
+type draftRequest struct {
+	Body        string              `json:"body"`
+	InReplyToId int                 `json:"in_reply_to_id"`
+	QuoteOfId   int                 `json:"quote_of_id"`
+	Visibility  string              `json:"visibility"`
+	Attachments []attachmentRequest `json:"attachments"`
+	Poll        *database.NewPoll   `json:"poll"`
+	PublishAt   *time.Time          `json:"publish_at"`
+}
+
+// readDraftRequest reads a draft from the request body and runs it through
+// the same checks as a chirp. It writes the error response and returns false
+// if the draft can't be saved.
+func (c *apiConfig) readDraftRequest(w http.ResponseWriter, r *http.Request, authorID int) (database.Draft, moderatedContent, bool) {
+	defer r.Body.Close()
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return database.Draft{}, moderatedContent{}, false
+	}
+	rBody := draftRequest{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return database.Draft{}, moderatedContent{}, false
+	}
+	content, err := c.cleanChirpBody(authorID, rBody.Body)
+	if err != nil {
+		respondWithCleanChirpError(w, content, err)
+		return database.Draft{}, moderatedContent{}, false
+	}
+	poll, pollContent, err := c.cleanPoll(rBody.Poll)
+	if err != nil {
+		respondWithCleanChirpError(w, pollContent, err)
+		return database.Draft{}, moderatedContent{}, false
+	}
+	return database.Draft{
+		Author:      authorID,
+		Body:        content.Text,
+		InReplyToID: rBody.InReplyToId,
+		QuoteOfID:   rBody.QuoteOfId,
+		Visibility:  rBody.Visibility,
+		Attachments: toAttachments(rBody.Attachments),
+		Poll:        poll,
+		PublishAt:   rBody.PublishAt,
+		Flags:       content.flags(),
+	}, content, true
+}
+
+// respondWithDraftError reports why a draft couldn't be saved or published.
+func respondWithDraftError(w http.ResponseWriter, err error) {
+	if respondWithAttachmentError(w, err) || respondWithPollError(w, err) {
+		return
+	}
+	switch err.Error() {
+	case "draft not found":
+		respondWithError(w, http.StatusNotFound, "Draft not found")
+	case "invalid visibility":
+		respondWithError(w, http.StatusBadRequest, "visibility must be public, unlisted, followers or direct")
+	case "publish time in the past":
+		respondWithError(w, http.StatusBadRequest, "publish_at must be in the future")
+	case "parent chirp not found":
+		respondWithError(w, http.StatusBadRequest, "Chirp being replied to does not exist")
+	case "quoted chirp not found":
+		respondWithError(w, http.StatusBadRequest, "Chirp being quoted does not exist")
+	case "blocked":
+		respondWithError(w, http.StatusForbidden, "Cannot interact with this user")
+	case "posting disabled":
+		respondWithError(w, http.StatusForbidden, "Posting has been disabled on your account")
+	default:
+		log.Printf("Error saving draft %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error saving draft")
+	}
+}
+
+func (c *apiConfig) createDraft(w http.ResponseWriter, draft database.Draft, content moderatedContent) {
+	draft, err := c.DB.CreateDraft(draft)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, draftResponse{Draft: draft, Moderation: content.Hits})
+}
+
+func (c *apiConfig) handlePostDraft(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	draft, content, ok := c.readDraftRequest(w, r, tokenClaims.Id)
+	if !ok {
+		return
+	}
+	c.createDraft(w, draft, content)
+}
+
+func (c *apiConfig) handleGetDrafts(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	drafts, err := c.DB.GetDrafts(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting drafts %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting drafts")
+		return
+	}
+	switch r.URL.Query().Get("scheduled") {
+	case "":
+	case "true", "false":
+		scheduled := r.URL.Query().Get("scheduled") == "true"
+		kept := []database.Draft{}
+		for _, draft := range drafts {
+			if (draft.PublishAt != nil) == scheduled {
+				kept = append(kept, draft)
+			}
+		}
+		drafts = kept
+	default:
+		respondWithError(w, http.StatusBadRequest, "scheduled must be true or false")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, drafts)
+}
+
+func (c *apiConfig) handleGetDraft(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	draft, err := c.DB.GetDraft(id, tokenClaims.Id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, draft)
+}
+
+// handlePutDraft replaces a draft, so fields left out are cleared: leaving
+// out publish_at turns a scheduled draft back into a plain one.
+func (c *apiConfig) handlePutDraft(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	draft, content, ok := c.readDraftRequest(w, r, tokenClaims.Id)
+	if !ok {
+		return
+	}
+	draft.ID = id
+
+	draft, err = c.DB.UpdateDraft(draft)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, draftResponse{Draft: draft, Moderation: content.Hits})
+}
+
+func (c *apiConfig) handleDeleteDraft(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.DeleteDraft(id, tokenClaims.Id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Draft deleted")
+}
+
+func (c *apiConfig) handlePublishDraft(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	chirp, err := c.DB.PublishDraft(id, tokenClaims.Id)
+	if err != nil {
+		respondWithDraftError(w, err)
+		return
+	}
+	c.respondWithChirp(w, http.StatusCreated, tokenClaims.Id, chirp)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"internal/database"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// engagementHandler builds a handler that applies a like, rechirp,
+// bookmark or pin action to the chirp in the path on behalf of the
+// authenticated user.
+func (c *apiConfig) engagementHandler(action func(chirpID, userID int) (database.Chirp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		chirp, err := action(id, tokenClaims.Id)
+		if err != nil {
+			if err.Error() == "chirp not found" {
+				respondWithError(w, http.StatusNotFound, "Chirp not found")
+				return
+			}
+			if err.Error() == "cannot rechirp" {
+				respondWithError(w, http.StatusForbidden, "Only public and unlisted chirps can be rechirped")
+				return
+			}
+			if err.Error() == "unauthorized" {
+				respondWithError(w, http.StatusForbidden, "You can only pin your own chirps")
+				return
+			}
+			if err.Error() == "too many pinned chirps" {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("You can pin at most %d chirps", database.MaxPinnedChirps))
+				return
+			}
+			log.Printf("Error updating engagement %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating chirp")
+			return
+		}
+		c.respondWithChirp(w, http.StatusOK, tokenClaims.Id, chirp)
+	}
+}
+
+func (c *apiConfig) handleGetUserLikes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	viewerID := c.optionalViewer(r)
+	page, err := c.DB.ListLikedChirps(id, viewerID, pageQuery)
+	if err != nil {
+		log.Printf("Error getting likes %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting likes")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, viewerID, page.Chirps)
+}
+
+func (c *apiConfig) handleGetBookmarks(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListBookmarks(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting bookmarks %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting bookmarks")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, tokenClaims.Id, page.Chirps)
+}
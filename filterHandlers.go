@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (c *apiConfig) handleGetFilters(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	filters, err := c.DB.GetKeywordFilters(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting filters %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting filters")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, filters)
+}
+
+// handlePostFilter adds a keyword filter. The filter expires at expires_at,
+// or after expires_in (such as 90m, 24h or 7d), or never if neither is set.
+func (c *apiConfig) handlePostFilter(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Phrase        string     `json:"phrase"`
+		Regex         bool       `json:"regex"`
+		WholeWord     bool       `json:"whole_word"`
+		CaseSensitive bool       `json:"case_sensitive"`
+		Action        string     `json:"action"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		ExpiresIn     string     `json:"expires_in"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+
+	if rBody.Action == "" {
+		rBody.Action = database.FilterActionHide
+	}
+	expiresAt := rBody.ExpiresAt
+	if rBody.ExpiresIn != "" {
+		d, err := parseDuration(rBody.ExpiresIn)
+		if err != nil || d <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a positive duration such as 90m, 24h or 7d")
+			return
+		}
+		t := time.Now().UTC().Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	filter, err := c.DB.CreateKeywordFilter(database.KeywordFilter{
+		UserID:        tokenClaims.Id,
+		Phrase:        rBody.Phrase,
+		Regex:         rBody.Regex,
+		WholeWord:     rBody.WholeWord,
+		CaseSensitive: rBody.CaseSensitive,
+		Action:        rBody.Action,
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		switch err.Error() {
+		case "empty phrase":
+			respondWithError(w, http.StatusBadRequest, "phrase is required")
+		case "unknown filter action":
+			respondWithError(w, http.StatusBadRequest, "action must be hide or collapse")
+		case "invalid pattern":
+			respondWithError(w, http.StatusBadRequest, "phrase is not a valid regular expression")
+		default:
+			log.Printf("Error creating filter %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error creating filter")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, filter)
+}
+
+func (c *apiConfig) handleDeleteFilter(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.DeleteKeywordFilter(id, tokenClaims.Id)
+	if err != nil {
+		if err.Error() == "filter not found" {
+			respondWithError(w, http.StatusNotFound, "Filter not found")
+			return
+		}
+		log.Printf("Error deleting filter %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting filter")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Filter deleted")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"internal/database"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (c *apiConfig) handleFollow(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.Follow(tokenClaims.Id, id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err.Error() == "blocked" {
+			respondWithError(w, http.StatusForbidden, "Cannot follow this user")
+			return
+		}
+		if err.Error() == "cannot follow yourself" {
+			respondWithError(w, http.StatusBadRequest, "Cannot follow yourself")
+			return
+		}
+		log.Printf("Error following user %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error following user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "User followed")
+}
+
+func (c *apiConfig) handleUnfollow(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.Unfollow(tokenClaims.Id, id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Error unfollowing user %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unfollowing user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "User unfollowed")
+}
+
+// followListHandler builds a handler listing one side of the follow graph of
+// the user in the path. The total size of the list is sent in X-Total-Count.
+func (c *apiConfig) followListHandler(list func(userID int, q database.PageQuery) (database.UserPage, error), count func(database.FollowCounts) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		pageQuery, err := parsePageQuery(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		page, err := list(id, pageQuery)
+		if err != nil {
+			log.Printf("Error getting users %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error getting users")
+			return
+		}
+		counts, err := c.DB.GetFollowCounts(id)
+		if err != nil {
+			log.Printf("Error counting follows %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error getting users")
+			return
+		}
+
+		users, err := c.presentUsers(c.optionalViewer(r), page.Users)
+		if err != nil {
+			log.Printf("Error presenting users %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error getting users")
+			return
+		}
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(count(counts)))
+		setPageHeaders(w, r, page.Next, page.Prev)
+		respondWithJSON(w, http.StatusOK, users)
+	}
+}
+
+func (c *apiConfig) handleGetTimeline(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.GetTimeline(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting timeline %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting timeline")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, tokenClaims.Id, page.Chirps)
+}
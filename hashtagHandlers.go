@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxAnalyticsBuckets = 1000
+
+// parseDuration extends time.ParseDuration with a "d" unit for days.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func durationParam(r *http.Request, name string, fallback time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := parseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 90m, 24h or 7d, got %q", name, value)
+	}
+	return d, nil
+}
+
+func (c *apiConfig) handleGetHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	chirpQuery, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chirpQuery.Hashtag = r.PathValue("tag")
+	chirpQuery.ViewerID = c.optionalViewer(r)
+
+	page, err := c.DB.QueryChirps(chirpQuery)
+	if err != nil {
+		log.Printf("Error getting chirps %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirps")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, chirpQuery.ViewerID, page.Chirps)
+}
+
+func (c *apiConfig) handleGetTrends(w http.ResponseWriter, r *http.Request) {
+	window, err := durationParam(r, "window", 24*time.Hour)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", maxPageLimit))
+			return
+		}
+	}
+
+	trends, err := c.DB.TrendingHashtags(window, limit)
+	if err != nil {
+		log.Printf("Error getting trends %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting trends")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, trends)
+}
+
+// handleGetHashtagAnalytics is a Chirpy Red feature.
+func (c *apiConfig) handleGetHashtagAnalytics(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, err := c.DB.GetUser(strconv.Itoa(tokenClaims.Id))
+	if err != nil {
+		log.Printf("Error getting user %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		return
+	}
+	if !user.IsChirpyRed {
+		respondWithError(w, http.StatusForbidden, "Hashtag analytics require Chirpy Red")
+		return
+	}
+
+	window, err := durationParam(r, "window", 7*24*time.Hour)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket, err := durationParam(r, "bucket", time.Hour)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if window/bucket > maxAnalyticsBuckets {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("window can span at most %d buckets", maxAnalyticsBuckets))
+		return
+	}
+
+	analytics, err := c.DB.GetHashtagAnalytics(r.PathValue("tag"), window, bucket)
+	if err != nil {
+		log.Printf("Error getting hashtag analytics %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting hashtag analytics")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, analytics)
+}
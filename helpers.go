@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"log"
@@ -15,7 +17,6 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) error
         return err
     }
     w.Header().Set("Content-Type", "application/json")
-    w.Header().Set("Access-Control-Allow-Origin", "*")
     w.WriteHeader(code)
     w.Write(response)
     return nil
@@ -25,14 +26,22 @@ func respondWithError(w http.ResponseWriter, code int, msg string) error {
     return respondWithJSON(w, code, map[string]string{"error": msg})
 }
 
+// randomToken returns n random bytes, hex encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func getAccessTokenData(r *http.Request, jwtSecret string) (MyCustomClaims, error) {
-    token := r.Header.Get("Authorization")
+    token := requestToken(r, accessCookieName)
 	if token == "" {
 		log.Printf("No token provided")
 		return MyCustomClaims{}, errors.New("No token provided")
 	}
-	// strip bearer
-    token = token[7:]
     
     claims := &MyCustomClaims{}
 	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
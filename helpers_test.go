@@ -0,0 +1,27 @@
+package main
+
+import (
+	"internal/database"
+	"path/filepath"
+	"testing"
+)
+
+// newTestConfig returns an apiConfig backed by a fresh database in a
+// temporary directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	return &apiConfig{
+		DB:            db,
+		jwtSecret:     "test-secret",
+		baseURL:       "http://chirpy.test",
+		mailer:        logMailer{},
+		notifier:      logNotifier{},
+		contentFilter: moderationPipeline{normalizeStage{}, newWordListStage("")},
+		moderators:    map[int]bool{},
+		maxMediaBytes: defaultMaxMediaBytes,
+	}
+}
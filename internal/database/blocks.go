@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// Block makes blockerID block blockedID. Any follow between the two is
+// removed, and from then on neither can follow the other, and the blocked
+// user can't reply to, quote or mention the blocker.
+func (db *DB) Block(blockerID, blockedID int) error {
+	if blockerID == blockedID {
+		return errors.New("cannot block yourself")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[blockedID]; !ok {
+		return errors.New("user not found")
+	}
+	if _, ok := dbStructure.Blocks[blockerID][blockedID]; ok {
+		return nil
+	}
+
+	if dbStructure.Blocks[blockerID] == nil {
+		dbStructure.Blocks[blockerID] = map[int]int64{}
+	}
+	dbStructure.Blocks[blockerID][blockedID] = time.Now().UnixNano()
+	dbStructure.removeFollow(blockerID, blockedID)
+	dbStructure.removeFollow(blockedID, blockerID)
+	return db.writeDB(dbStructure)
+}
+
+func (db *DB) Unblock(blockerID, blockedID int) error {
+	return db.removeRelation(func(dbStructure DBStructure) map[int]map[int]int64 {
+		return dbStructure.Blocks
+	}, blockerID, blockedID)
+}
+
+// Mute hides mutedID's chirps from muterID without telling mutedID.
+func (db *DB) Mute(muterID, mutedID int) error {
+	if muterID == mutedID {
+		return errors.New("cannot mute yourself")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[mutedID]; !ok {
+		return errors.New("user not found")
+	}
+	if _, ok := dbStructure.Mutes[muterID][mutedID]; ok {
+		return nil
+	}
+
+	if dbStructure.Mutes[muterID] == nil {
+		dbStructure.Mutes[muterID] = map[int]int64{}
+	}
+	dbStructure.Mutes[muterID][mutedID] = time.Now().UnixNano()
+	return db.writeDB(dbStructure)
+}
+
+func (db *DB) Unmute(muterID, mutedID int) error {
+	return db.removeRelation(func(dbStructure DBStructure) map[int]map[int]int64 {
+		return dbStructure.Mutes
+	}, muterID, mutedID)
+}
+
+func (db *DB) removeRelation(relations func(DBStructure) map[int]map[int]int64, fromID, toID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[toID]; !ok {
+		return errors.New("user not found")
+	}
+	edges := relations(dbStructure)
+	if _, ok := edges[fromID][toID]; !ok {
+		return nil
+	}
+	delete(edges[fromID], toID)
+	if len(edges[fromID]) == 0 {
+		delete(edges, fromID)
+	}
+	return db.writeDB(dbStructure)
+}
+
+// ListBlocked returns the users userID has blocked, most recent first.
+func (db *DB) ListBlocked(userID int, q PageQuery) (UserPage, error) {
+	return db.listRelations(func(dbStructure DBStructure) map[int]int64 {
+		return dbStructure.Blocks[userID]
+	}, q)
+}
+
+// ListMuted returns the users userID has muted, most recent first.
+func (db *DB) ListMuted(userID int, q PageQuery) (UserPage, error) {
+	return db.listRelations(func(dbStructure DBStructure) map[int]int64 {
+		return dbStructure.Mutes[userID]
+	}, q)
+}
+
+// isBlocked reports whether either user has blocked the other.
+func (dbStructure *DBStructure) isBlocked(a, b int) bool {
+	_, ab := dbStructure.Blocks[a][b]
+	_, ba := dbStructure.Blocks[b][a]
+	return ab || ba
+}
+
+// hiddenAuthors returns the users whose chirps viewerID shouldn't see: those
+// blocked in either direction and those viewerID muted. Anonymous viewers,
+// with an ID of 0, see everyone.
+func (dbStructure *DBStructure) hiddenAuthors(viewerID int) map[int]bool {
+	hidden := map[int]bool{}
+	if viewerID == 0 {
+		return hidden
+	}
+	for blockedID := range dbStructure.Blocks[viewerID] {
+		hidden[blockedID] = true
+	}
+	for blockerID, blocked := range dbStructure.Blocks {
+		if _, ok := blocked[viewerID]; ok {
+			hidden[blockerID] = true
+		}
+	}
+	for mutedID := range dbStructure.Mutes[viewerID] {
+		hidden[mutedID] = true
+	}
+	return hidden
+}
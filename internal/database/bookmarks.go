@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// Bookmark saves a chirp for userID. Bookmarks are private: nobody else can
+// see who saved a chirp, and chirps don't count them. Saving a chirp twice
+// is a no-op.
+func (db *DB) Bookmark(chirpID, userID int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted || !dbStructure.canView(userID, chirp) {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if _, saved := dbStructure.Bookmarks[userID][chirpID]; saved {
+		return chirp, nil
+	}
+	if dbStructure.Bookmarks[userID] == nil {
+		dbStructure.Bookmarks[userID] = map[int]int64{}
+	}
+	dbStructure.Bookmarks[userID][chirpID] = time.Now().UTC().UnixNano()
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// Unbookmark removes a chirp from userID's bookmarks. Removing a chirp that
+// isn't saved is a no-op.
+func (db *DB) Unbookmark(chirpID, userID int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if _, saved := dbStructure.Bookmarks[userID][chirpID]; !saved {
+		return chirp, nil
+	}
+	delete(dbStructure.Bookmarks[userID], chirpID)
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// ListBookmarks returns the chirps userID has saved, most recently saved
+// first. Chirps they can no longer see are left out.
+func (db *DB) ListBookmarks(userID int, q PageQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	view := dbStructure.viewFilterFor(userID)
+	items := []PageKey{}
+	for chirpID, savedAt := range dbStructure.Bookmarks[userID] {
+		if chirp, ok := dbStructure.Chirps[chirpID]; ok && !chirp.Deleted && !view.hides(chirp) {
+			items = append(items, PageKey{ID: chirpID, Key: savedAt})
+		}
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
+
+// removeBookmarks drops a removed chirp from everyone's bookmarks.
+func (dbStructure *DBStructure) removeBookmarks(chirp Chirp) {
+	for _, saved := range dbStructure.Bookmarks {
+		delete(saved, chirp.ID)
+	}
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ChirpVersion is a body a chirp had before it was edited.
+type ChirpVersion struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// UpdateChirp replaces the body of a chirp, keeping the previous body in the
+// chirp's history. Only the author can edit a chirp.
+func (db *DB) UpdateChirp(id, author_id int, body string) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[id]
+	if !ok || chirp.Deleted {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if chirp.Author != author_id {
+		return Chirp{}, errors.New("unauthorized")
+	}
+	if dbStructure.Users[author_id].PostingDisabled {
+		return Chirp{}, errors.New("posting disabled")
+	}
+	if chirp.Body == body {
+		return chirp, nil
+	}
+
+	now := time.Now().UTC()
+	dbStructure.ChirpHistory[id] = append(dbStructure.ChirpHistory[id], ChirpVersion{
+		Body:       chirp.Body,
+		CreatedAt:  chirp.UpdatedAt,
+		ReplacedAt: now,
+	})
+	dbStructure.SearchIndex.remove(chirp)
+	dbStructure.unindexHashtags(chirp)
+	chirp.Body = body
+	chirp.Entities = dbStructure.extractEntities(body)
+	chirp.UpdatedAt = now
+	chirp.Edited = true
+	dbStructure.Chirps[id] = chirp
+	dbStructure.SearchIndex.add(chirp)
+	dbStructure.indexHashtags(chirp)
+	dbStructure.recordMentions(chirp, false)
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetChirpHistory returns the previous versions of a chirp, oldest first.
+// Chirps viewerID can't read are reported as not found.
+func (db *DB) GetChirpHistory(id, viewerID int) ([]ChirpVersion, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	if chirp, ok := dbStructure.Chirps[id]; !ok || chirp.Deleted || !dbStructure.canView(viewerID, chirp) {
+		return nil, errors.New("chirp not found")
+	}
+	versions := dbStructure.ChirpHistory[id]
+	if versions == nil {
+		versions = []ChirpVersion{}
+	}
+	return versions, nil
+}
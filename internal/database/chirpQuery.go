@@ -0,0 +1,140 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	OrderByID         = "id"
+	OrderByCreatedAt  = "created_at"
+	OrderByEngagement = "engagement"
+)
+
+// LinkPattern matches the links in a chirp. Everything that needs to know
+// what counts as a link, such as length limits and spam scoring, uses it.
+var LinkPattern = regexp.MustCompile(`(?i)\bhttps?://\S+`)
+
+// ChirpQuery filters and orders chirps. All filters are combined with AND and
+// zero values mean "no filter".
+type ChirpQuery struct {
+	AuthorIDs  []int
+	Since      time.Time
+	Until      time.Time
+	SinceID    int
+	MaxID      int
+	Contains   string
+	HasMention bool
+	HasLink    bool
+	Hashtag    string
+	OrderBy    string
+	Page       PageQuery
+	// ViewerID leaves out chirps that user can't read, or has hidden by
+	// blocks, mutes and keyword filters.
+	ViewerID int
+}
+
+type ChirpPage struct {
+	Chirps []Chirp
+	Next   *PageKey
+	Prev   *PageKey
+}
+
+func (q ChirpQuery) matches(chirp Chirp) bool {
+	if chirp.Deleted {
+		return false
+	}
+	if len(q.AuthorIDs) > 0 {
+		found := false
+		for _, authorID := range q.AuthorIDs {
+			if chirp.Author == authorID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !chirp.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.SinceID > 0 && chirp.ID <= q.SinceID {
+		return false
+	}
+	if q.MaxID > 0 && chirp.ID > q.MaxID {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(strings.ToLower(chirp.Body), strings.ToLower(q.Contains)) {
+		return false
+	}
+	if q.HasMention && len(chirp.Entities.Mentions) == 0 {
+		return false
+	}
+	if q.HasLink && !LinkPattern.MatchString(chirp.Body) {
+		return false
+	}
+	return true
+}
+
+// Engagement is the number of interactions a chirp has received.
+func (c Chirp) Engagement() int {
+	return c.ReplyCount + c.LikeCount + c.RechirpCount + c.QuoteCount
+}
+
+func (q ChirpQuery) key(chirp Chirp) PageKey {
+	switch q.OrderBy {
+	case OrderByCreatedAt:
+		return PageKey{ID: chirp.ID, Key: chirp.CreatedAt.UnixNano()}
+	case OrderByEngagement:
+		return PageKey{ID: chirp.ID, Key: int64(chirp.Engagement())}
+	}
+	return PageKey{ID: chirp.ID, Key: int64(chirp.ID)}
+}
+
+// QueryChirps returns one page of the chirps matching q. The database is a
+// single JSON file, so it is still read whole, but matching chirps are
+// range-scanned by paginate instead of sorted, and only the chirps on the
+// page are copied out.
+func (db *DB) QueryChirps(q ChirpQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	view := dbStructure.viewFilterFor(q.ViewerID)
+	// listing chirps of given authors shows their profiles, which include
+	// unlisted chirps; other lists are global
+	global := len(q.AuthorIDs) == 0 || q.Hashtag != ""
+	items := []PageKey{}
+	if q.Hashtag != "" {
+		// only look at the chirps the hashtag index points to
+		for id := range dbStructure.Hashtags[NormalizeHashtag(q.Hashtag)] {
+			chirp, ok := dbStructure.Chirps[id]
+			if ok && q.matches(chirp) && !view.hides(chirp) && view.listed(chirp) {
+				items = append(items, q.key(chirp))
+			}
+		}
+	} else {
+		for _, chirp := range dbStructure.Chirps {
+			if q.matches(chirp) && !view.hides(chirp) && (!global || view.listed(chirp)) {
+				items = append(items, q.key(chirp))
+			}
+		}
+	}
+	window, next, prev := paginate(items, q.Page)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
@@ -5,24 +5,81 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type DB struct {
 	path string
 	mu   *sync.RWMutex
+	// txMu serializes read-modify-write cycles so concurrent writers
+	// don't overwrite each other's changes.
+	txMu *sync.Mutex
 }
 
 type DBStructure struct {
 	Chirps map[int]Chirp `json:"chirps"`
+	LastChirpID int `json:"lastChirpID"`
 	Users  map[int]User  `json:"users"`
 	RevokedTokens map[string]bool `json:"revokedTokens"`
+	UsedMagicLinks map[string]int64 `json:"usedMagicLinks"`
+	Identities map[int]Identity `json:"identities"`
+	OIDCLogins map[string]OIDCLogin `json:"oidcLogins"`
+	SecurityEvents map[int]SecurityEvent `json:"securityEvents"`
+	KnownDevices map[int][]KnownDevice `json:"knownDevices"`
+	ChirpHistory map[int][]ChirpVersion `json:"chirpHistory"`
+	SearchIndex SearchIndex `json:"searchIndex"`
+	Hashtags map[string]map[int]int64 `json:"hashtags"`
+	HashtagIndexVersion int `json:"hashtagIndexVersion"`
+	Mentions map[int]map[int]Mention `json:"mentions"`
+	Replies map[int][]int `json:"replies"`
+	Likes map[int]map[int]int64 `json:"likes"`
+	UserLikes map[int]map[int]int64 `json:"userLikes"`
+	Rechirps map[int]map[int]int64 `json:"rechirps"`
+	Following map[int]map[int]int64 `json:"following"`
+	Followers map[int]map[int]int64 `json:"followers"`
+	TimelineMode string `json:"timelineMode"`
+	Timelines map[int]map[int]int64 `json:"timelines"`
+	Blocks map[int]map[int]int64 `json:"blocks"`
+	Mutes map[int]map[int]int64 `json:"mutes"`
+	KeywordFilters map[int]KeywordFilter `json:"keywordFilters"`
+	Reports map[int]Report `json:"reports"`
+	ModerationCases map[int]ModerationCase `json:"moderationCases"`
+	LastModerationCaseID int `json:"lastModerationCaseID"`
+	ModerationDecisions map[int]ModerationDecision `json:"moderationDecisions"`
+	Drafts map[int]Draft `json:"drafts"`
+	Conversations map[int]Conversation `json:"conversations"`
+	LastConversationID int `json:"lastConversationID"`
+	ConversationMembers map[int]map[int]ConversationMember `json:"conversationMembers"`
+	Messages map[int]Message `json:"messages"`
+	LastMessageID int `json:"lastMessageID"`
+	Media map[int]Media `json:"media"`
+	PollVotes map[int]map[int]int `json:"pollVotes"`
+	Bookmarks map[int]map[int]int64 `json:"bookmarks"`
+	Lists map[int]List `json:"lists"`
+	LastListID int `json:"lastListID"`
+	ListMembers map[int]map[int]int64 `json:"listMembers"`
 }
 
 type Chirp struct {
 	ID   int    `json:"id"`
 	Body string `json:"body"`
 	Author int `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Edited bool `json:"edited"`
+	Entities ChirpEntities `json:"entities"`
+	InReplyToID int `json:"in_reply_to_id,omitempty"`
+	ReplyCount int `json:"reply_count"`
+	QuoteOfID int `json:"quote_of_id,omitempty"`
+	LikeCount int `json:"like_count"`
+	RechirpCount int `json:"rechirp_count"`
+	QuoteCount int `json:"quote_count"`
+	Visibility string `json:"visibility"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Poll *Poll `json:"poll,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 type User struct {
@@ -30,14 +87,40 @@ type User struct {
 	Email string `json:"email"`
 	Password string `json:"password"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	Handle string `json:"handle"`
+	PostingDisabled bool `json:"posting_disabled"`
+	AllowDMsFrom string `json:"allow_dms_from,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio string `json:"bio,omitempty"`
+	Website string `json:"website,omitempty"`
+	Location string `json:"location,omitempty"`
+	AvatarMediaID int `json:"avatar_media_id,omitempty"`
+	PinnedChirpIDs []int `json:"pinned_chirp_ids,omitempty"`
 }
 
 func NewDB(path string) (*DB, error) {
 	db := &DB{
 		path: path,
 		mu:   &sync.RWMutex{},
+		txMu: &sync.Mutex{},
 	}
 	err := db.ensureDB()
+	if err != nil {
+		return db, err
+	}
+	err = db.ensureSearchIndex()
+	if err != nil {
+		return db, err
+	}
+	err = db.ensureHandles()
+	if err != nil {
+		return db, err
+	}
+	err = db.ensureHashtagIndex()
+	if err != nil {
+		return db, err
+	}
+	err = db.ensureVisibility()
 	return db, err
 }
 
@@ -49,40 +132,145 @@ func DeleteDB(path string) (bool, error){
 	return true, nil
 }
 
-func (db *DB) CreateChirp(body string, author_id int ) (Chirp, error) {
+// ChirpOptions are the optional settings of a new chirp.
+type ChirpOptions struct {
+	InReplyToID int
+	QuoteOfID   int
+	Visibility  string
+	Attachments []Attachment
+	Poll        *NewPoll
+}
+
+func (db *DB) CreateChirp(body string, author_id int, opts ChirpOptions) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	id := len(dbStructure.Chirps) + 1
+	chirp, err := dbStructure.insertChirp(body, author_id, opts)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// insertChirp adds a new chirp and updates every index that refers to it.
+func (dbStructure *DBStructure) insertChirp(body string, author_id int, opts ChirpOptions) (Chirp, error) {
+	if dbStructure.Users[author_id].PostingDisabled {
+		return Chirp{}, errors.New("posting disabled")
+	}
+	err := dbStructure.checkChirpOptions(author_id, opts)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	// ids of deleted chirps are never reused, so drafts and moderation
+	// cases pointing at a deleted chirp can't end up on a new one
+	id := dbStructure.LastChirpID + 1
+	for chirpID := range dbStructure.Chirps {
+		if chirpID >= id {
+			id = chirpID + 1
+		}
+	}
+	dbStructure.LastChirpID = id
+	now := time.Now().UTC()
 	chirp := Chirp{
 		ID:   id,
 		Body: body,
 		Author: author_id,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Entities: dbStructure.extractEntities(body),
+		InReplyToID: opts.InReplyToID,
+		QuoteOfID: opts.QuoteOfID,
+		Visibility: opts.Visibility,
+	}
+	if chirp.Visibility == "" {
+		chirp.Visibility = VisibilityPublic
+	}
+	if len(opts.Attachments) > 0 {
+		dbStructure.attachMedia(&chirp, opts.Attachments)
+	}
+	if opts.Poll != nil {
+		chirp.Poll = newPoll(opts.Poll)
 	}
 	dbStructure.Chirps[id] = chirp
+	dbStructure.SearchIndex.add(chirp)
+	dbStructure.indexHashtags(chirp)
+	dbStructure.recordMentions(chirp, false)
+	if chirp.InReplyToID != 0 {
+		dbStructure.addReply(chirp)
+	}
+	if chirp.QuoteOfID != 0 {
+		quoted := dbStructure.Chirps[chirp.QuoteOfID]
+		quoted.QuoteCount++
+		dbStructure.Chirps[quoted.ID] = quoted
+	}
+	dbStructure.fanOutChirp(chirp)
+	return chirp, nil
+}
 
-	err = db.writeDB(dbStructure)
+// checkChirpOptions makes sure the visibility is known, that the chirps a
+// new chirp replies to or quotes exist, can be read by author_id and that
+// their authors haven't blocked, or been blocked by, author_id, and that its
+// attachments and poll are valid.
+func (dbStructure *DBStructure) checkChirpOptions(author_id int, opts ChirpOptions) error {
+	err := checkVisibility(opts.Visibility)
 	if err != nil {
-		return Chirp{}, err
+		return err
 	}
-
-	return chirp, nil
+	err = dbStructure.checkAttachments(author_id, opts.Attachments)
+	if err != nil {
+		return err
+	}
+	err = checkPoll(opts.Poll, time.Now())
+	if err != nil {
+		return err
+	}
+	if opts.InReplyToID != 0 {
+		parent, ok := dbStructure.Chirps[opts.InReplyToID]
+		if !ok || parent.Deleted || !dbStructure.viewFilterFor(author_id).canSee(parent) {
+			return errors.New("parent chirp not found")
+		}
+		if dbStructure.isBlocked(author_id, parent.Author) {
+			return errors.New("blocked")
+		}
+	}
+	if opts.QuoteOfID != 0 {
+		quoted, ok := dbStructure.Chirps[opts.QuoteOfID]
+		if !ok || quoted.Deleted || !dbStructure.viewFilterFor(author_id).canSee(quoted) {
+			return errors.New("quoted chirp not found")
+		}
+		if dbStructure.isBlocked(author_id, quoted.Author) {
+			return errors.New("blocked")
+		}
+	}
+	return nil
 }
+
 func (db *DB) DeleteChirp(id, author_id int) (error){
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return err
 	}
 	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
+	if !ok || chirp.Deleted {
 		return errors.New("chirp not found")
 	}
 	if chirp.Author != author_id {
 		return errors.New("unauthorized")
 	}
-	delete(dbStructure.Chirps, id)
+	dbStructure.removeChirp(chirp)
 	err = db.writeDB(dbStructure)
 	if err != nil {
 		return err
@@ -90,7 +278,34 @@ func (db *DB) DeleteChirp(id, author_id int) (error){
 	return nil
 
 }
+
+// removeChirp deletes a chirp and drops it from every index. A chirp that has
+// replies is kept as a tombstone so its thread stays connected.
+func (dbStructure *DBStructure) removeChirp(chirp Chirp) {
+	delete(dbStructure.ChirpHistory, chirp.ID)
+	dbStructure.keepReportedChirp(chirp)
+	dbStructure.SearchIndex.remove(chirp)
+	dbStructure.unindexHashtags(chirp)
+	dbStructure.removeMentions(chirp)
+	dbStructure.removeEngagement(chirp)
+	dbStructure.unfanOutChirp(chirp)
+	dbStructure.detachMedia(chirp)
+	dbStructure.removeBookmarks(chirp)
+	dbStructure.unpinChirp(chirp)
+
+	if len(dbStructure.Replies[chirp.ID]) > 0 {
+		dbStructure.tombstone(chirp)
+		return
+	}
+	delete(dbStructure.Chirps, chirp.ID)
+	if chirp.InReplyToID != 0 {
+		dbStructure.removeReply(chirp)
+	}
+}
+
 func (db *DB) CreateUser(email, password string) (User, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return User{}, err
@@ -102,6 +317,7 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 		Email:email,
 		Password: password,
 		IsChirpyRed: false,
+		Handle: dbStructure.defaultHandle(id),
 	}
 	dbStructure.Users[id] = user
 
@@ -114,6 +330,8 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 }
 
 func (db *DB) UpdateUser(id string, email, password string) (User, error){
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return User{}, err
@@ -141,6 +359,9 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 
 	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
 	for _, chirp := range dbStructure.Chirps {
+		if chirp.Deleted {
+			continue
+		}
 		chirps = append(chirps, chirp)
 	}
 
@@ -173,7 +394,7 @@ func (db *DB) GetChirp(id string) (Chirp, error) {
 		return Chirp{}, err
 	}
 	chirp, ok := dbStructure.Chirps[intId]
-	if !ok {
+	if !ok || chirp.Deleted {
 		return Chirp{}, errors.New("chirp not found")
 	}
 
@@ -196,6 +417,16 @@ func (db *DB) GetUser(id string) (User, error) {
 	return User{
 		ID: user.ID,
 		Email: user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+		Handle: user.Handle,
+		PostingDisabled: user.PostingDisabled,
+		AllowDMsFrom: user.AllowDMsFrom,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		Website: user.Website,
+		Location: user.Location,
+		AvatarMediaID: user.AvatarMediaID,
+		PinnedChirpIDs: user.PinnedChirpIDs,
 	}, nil
 }
 
@@ -205,7 +436,7 @@ func (db *DB) GetUserByEmail(email string) (User, error){
 		return User{}, err
 	}
 	for _, user := range dbStructure.Users {
-		if user.Email == email {
+		if strings.EqualFold(user.Email, email) {
 			return user, nil
 		}
 	}
@@ -214,6 +445,8 @@ func (db *DB) GetUserByEmail(email string) (User, error){
 }
 
 func (db *DB) UpgradeUserToChirpyRed(id int) ( error){
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return err
@@ -236,6 +469,39 @@ func (db *DB) createDB() error {
 		Chirps: map[int]Chirp{},
 		Users:  map[int]User{},
 		RevokedTokens: map[string]bool{},
+		UsedMagicLinks: map[string]int64{},
+		Identities: map[int]Identity{},
+		OIDCLogins: map[string]OIDCLogin{},
+		SecurityEvents: map[int]SecurityEvent{},
+		KnownDevices: map[int][]KnownDevice{},
+		ChirpHistory: map[int][]ChirpVersion{},
+		SearchIndex: newSearchIndex(),
+		Hashtags: map[string]map[int]int64{},
+		HashtagIndexVersion: hashtagIndexVersion,
+		Mentions: map[int]map[int]Mention{},
+		Replies: map[int][]int{},
+		Likes: map[int]map[int]int64{},
+		UserLikes: map[int]map[int]int64{},
+		Rechirps: map[int]map[int]int64{},
+		Following: map[int]map[int]int64{},
+		Followers: map[int]map[int]int64{},
+		TimelineMode: FanOutOnRead,
+		Timelines: map[int]map[int]int64{},
+		Blocks: map[int]map[int]int64{},
+		Mutes: map[int]map[int]int64{},
+		KeywordFilters: map[int]KeywordFilter{},
+		Reports: map[int]Report{},
+		ModerationCases: map[int]ModerationCase{},
+		ModerationDecisions: map[int]ModerationDecision{},
+		Drafts: map[int]Draft{},
+		Conversations: map[int]Conversation{},
+		ConversationMembers: map[int]map[int]ConversationMember{},
+		Messages: map[int]Message{},
+		Media: map[int]Media{},
+		PollVotes: map[int]map[int]int{},
+		Bookmarks: map[int]map[int]int64{},
+		Lists: map[int]List{},
+		ListMembers: map[int]map[int]int64{},
 	}
 	return db.writeDB(dbStructure)
 }
@@ -248,6 +514,119 @@ func (db *DB) ensureDB() error {
 	return err
 }
 
+// ensureMaps initializes collections that are missing from database files
+// written before they were introduced.
+func (dbStructure *DBStructure) ensureMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.RevokedTokens == nil {
+		dbStructure.RevokedTokens = map[string]bool{}
+	}
+	if dbStructure.UsedMagicLinks == nil {
+		dbStructure.UsedMagicLinks = map[string]int64{}
+	}
+	if dbStructure.Identities == nil {
+		dbStructure.Identities = map[int]Identity{}
+	}
+	if dbStructure.OIDCLogins == nil {
+		dbStructure.OIDCLogins = map[string]OIDCLogin{}
+	}
+	if dbStructure.SecurityEvents == nil {
+		dbStructure.SecurityEvents = map[int]SecurityEvent{}
+	}
+	if dbStructure.KnownDevices == nil {
+		dbStructure.KnownDevices = map[int][]KnownDevice{}
+	}
+	if dbStructure.ChirpHistory == nil {
+		dbStructure.ChirpHistory = map[int][]ChirpVersion{}
+	}
+	if dbStructure.Hashtags == nil {
+		dbStructure.Hashtags = map[string]map[int]int64{}
+	}
+	if dbStructure.Mentions == nil {
+		dbStructure.Mentions = map[int]map[int]Mention{}
+	}
+	if dbStructure.Replies == nil {
+		dbStructure.Replies = map[int][]int{}
+	}
+	if dbStructure.Likes == nil {
+		dbStructure.Likes = map[int]map[int]int64{}
+	}
+	if dbStructure.UserLikes == nil {
+		dbStructure.UserLikes = map[int]map[int]int64{}
+	}
+	if dbStructure.Rechirps == nil {
+		dbStructure.Rechirps = map[int]map[int]int64{}
+	}
+	if dbStructure.Following == nil {
+		dbStructure.Following = map[int]map[int]int64{}
+	}
+	if dbStructure.Followers == nil {
+		dbStructure.Followers = map[int]map[int]int64{}
+	}
+	if dbStructure.TimelineMode == "" {
+		dbStructure.TimelineMode = FanOutOnRead
+	}
+	if dbStructure.Timelines == nil {
+		dbStructure.Timelines = map[int]map[int]int64{}
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = map[int]map[int]int64{}
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = map[int]map[int]int64{}
+	}
+	if dbStructure.KeywordFilters == nil {
+		dbStructure.KeywordFilters = map[int]KeywordFilter{}
+	}
+	if dbStructure.Reports == nil {
+		dbStructure.Reports = map[int]Report{}
+	}
+	if dbStructure.ModerationCases == nil {
+		dbStructure.ModerationCases = map[int]ModerationCase{}
+	}
+	if dbStructure.ModerationDecisions == nil {
+		dbStructure.ModerationDecisions = map[int]ModerationDecision{}
+	}
+	if dbStructure.Drafts == nil {
+		dbStructure.Drafts = map[int]Draft{}
+	}
+	if dbStructure.Conversations == nil {
+		dbStructure.Conversations = map[int]Conversation{}
+	}
+	if dbStructure.ConversationMembers == nil {
+		dbStructure.ConversationMembers = map[int]map[int]ConversationMember{}
+	}
+	if dbStructure.Messages == nil {
+		dbStructure.Messages = map[int]Message{}
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = map[int]Media{}
+	}
+	if dbStructure.PollVotes == nil {
+		dbStructure.PollVotes = map[int]map[int]int{}
+	}
+	if dbStructure.Bookmarks == nil {
+		dbStructure.Bookmarks = map[int]map[int]int64{}
+	}
+	if dbStructure.Lists == nil {
+		dbStructure.Lists = map[int]List{}
+	}
+	if dbStructure.ListMembers == nil {
+		dbStructure.ListMembers = map[int]map[int]int64{}
+	}
+	if dbStructure.SearchIndex.Postings == nil {
+		dbStructure.SearchIndex.Postings = map[string]map[int][]int{}
+	}
+	if dbStructure.SearchIndex.DocLengths == nil {
+		dbStructure.SearchIndex.DocLengths = map[int]int{}
+	}
+}
+
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -261,11 +640,14 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return dbStructure, err
 	}
+	dbStructure.ensureMaps()
 
 	return dbStructure, nil
 }
 
 func (db *DB) RevokeToken(token string)(bool, error){
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return false, err
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Draft is a chirp that hasn't been published yet. A draft with a PublishAt
+// time is scheduled, and PublishDueDrafts publishes it once that time has
+// passed. Flags are the moderation hits found when the draft was saved,
+// which go to the moderation queue when it's published. Error says why a
+// scheduled draft couldn't be published; it's then kept as a plain draft.
+type Draft struct {
+	ID          int             `json:"id"`
+	Author      int             `json:"author_id"`
+	Body        string          `json:"body"`
+	InReplyToID int             `json:"in_reply_to_id,omitempty"`
+	QuoteOfID   int             `json:"quote_of_id,omitempty"`
+	Visibility  string          `json:"visibility,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Poll        *NewPoll        `json:"poll,omitempty"`
+	PublishAt   *time.Time      `json:"publish_at,omitempty"`
+	Flags       []ModerationHit `json:"flags,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func (d Draft) options() ChirpOptions {
+	return ChirpOptions{InReplyToID: d.InReplyToID, QuoteOfID: d.QuoteOfID, Visibility: d.Visibility, Attachments: d.Attachments, Poll: d.Poll}
+}
+
+// checkDraft validates a draft being saved the way insertChirp will when it
+// is published, so problems show up while the author can still fix them.
+func (dbStructure *DBStructure) checkDraft(draft Draft, now time.Time) error {
+	if draft.PublishAt != nil && !draft.PublishAt.After(now) {
+		return errors.New("publish time in the past")
+	}
+	if draft.PublishAt != nil && dbStructure.Users[draft.Author].PostingDisabled {
+		return errors.New("posting disabled")
+	}
+	// a scheduled poll has to stay open for a while once it's published
+	if draft.PublishAt != nil && draft.Poll != nil && !draft.Poll.ClosesAt.After(*draft.PublishAt) {
+		return errors.New("invalid poll closing time")
+	}
+	return dbStructure.checkChirpOptions(draft.Author, draft.options())
+}
+
+func (db *DB) CreateDraft(draft Draft) (Draft, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	now := time.Now().UTC()
+	err = dbStructure.checkDraft(draft, now)
+	if err != nil {
+		return Draft{}, err
+	}
+
+	id := 1
+	for draftID := range dbStructure.Drafts {
+		if draftID >= id {
+			id = draftID + 1
+		}
+	}
+	draft.ID = id
+	draft.Error = ""
+	draft.CreatedAt = now
+	draft.UpdatedAt = now
+	dbStructure.Drafts[id] = draft
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// UpdateDraft replaces the body, targets and schedule of one of the author's
+// drafts. A nil PublishAt unschedules it.
+func (db *DB) UpdateDraft(draft Draft) (Draft, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	existing, ok := dbStructure.Drafts[draft.ID]
+	if !ok || existing.Author != draft.Author {
+		return Draft{}, errors.New("draft not found")
+	}
+	now := time.Now().UTC()
+	err = dbStructure.checkDraft(draft, now)
+	if err != nil {
+		return Draft{}, err
+	}
+
+	draft.Error = ""
+	draft.CreatedAt = existing.CreatedAt
+	draft.UpdatedAt = now
+	dbStructure.Drafts[draft.ID] = draft
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns the drafts of authorID, scheduled ones first in the
+// order they will be published.
+func (db *DB) GetDrafts(authorID int) ([]Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	drafts := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.Author == authorID {
+			drafts = append(drafts, draft)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool {
+		a, b := drafts[i], drafts[j]
+		if (a.PublishAt == nil) != (b.PublishAt == nil) {
+			return a.PublishAt != nil
+		}
+		if a.PublishAt != nil && !a.PublishAt.Equal(*b.PublishAt) {
+			return a.PublishAt.Before(*b.PublishAt)
+		}
+		return a.ID < b.ID
+	})
+	return drafts, nil
+}
+
+func (db *DB) GetDraft(id, authorID int) (Draft, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	draft, ok := dbStructure.Drafts[id]
+	if !ok || draft.Author != authorID {
+		return Draft{}, errors.New("draft not found")
+	}
+	return draft, nil
+}
+
+// DeleteDraft discards a draft, which also cancels it if it's scheduled.
+func (db *DB) DeleteDraft(id, authorID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	draft, ok := dbStructure.Drafts[id]
+	if !ok || draft.Author != authorID {
+		return errors.New("draft not found")
+	}
+	delete(dbStructure.Drafts, id)
+	return db.writeDB(dbStructure)
+}
+
+// PublishDraft publishes one of the author's drafts straight away, whether
+// or not it's scheduled.
+func (db *DB) PublishDraft(id, authorID int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	draft, ok := dbStructure.Drafts[id]
+	if !ok || draft.Author != authorID {
+		return Chirp{}, errors.New("draft not found")
+	}
+	chirp, err := dbStructure.publishDraft(draft)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PublishDueDrafts publishes every scheduled draft whose time has come by
+// now, in the order they were scheduled for. Since the schedule is stored
+// with the drafts, a call after downtime catches up on everything that came
+// due meanwhile, and each draft is published exactly once because it's
+// removed in the same write that adds its chirp. Drafts that can no longer
+// be published, say because the chirp they reply to was deleted, are
+// unscheduled and returned with their Error set.
+func (db *DB) PublishDueDrafts(now time.Time) ([]Chirp, []Draft, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	due := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.PublishAt != nil && !draft.PublishAt.After(now) {
+			due = append(due, draft)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil, nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].PublishAt.Equal(*due[j].PublishAt) {
+			return due[i].PublishAt.Before(*due[j].PublishAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+
+	published := []Chirp{}
+	failed := []Draft{}
+	for _, draft := range due {
+		chirp, err := dbStructure.publishDraft(draft)
+		if err != nil {
+			draft.PublishAt = nil
+			draft.Error = err.Error()
+			draft.UpdatedAt = now.UTC()
+			dbStructure.Drafts[draft.ID] = draft
+			failed = append(failed, draft)
+			continue
+		}
+		published = append(published, chirp)
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return nil, nil, err
+	}
+	return published, failed, nil
+}
+
+// publishDraft turns a draft into a chirp and queues its moderation flags.
+func (dbStructure *DBStructure) publishDraft(draft Draft) (Chirp, error) {
+	chirp, err := dbStructure.insertChirp(draft.Body, draft.Author, draft.options())
+	if err != nil {
+		return Chirp{}, err
+	}
+	if len(draft.Flags) > 0 {
+		dbStructure.flagChirp(chirp, draft.Flags)
+	}
+	delete(dbStructure.Drafts, draft.ID)
+	return chirp, nil
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	engagementLike    = "like"
+	engagementRechirp = "rechirp"
+)
+
+type ViewerState struct {
+	Liked      bool
+	Rechirped  bool
+	Bookmarked bool
+	Filtered   []FilterMatch
+	// PollVote is the option the viewer voted for, if the chirp has a poll
+	// and they voted.
+	PollVote *int
+}
+
+func (db *DB) LikeChirp(chirpID, userID int) (Chirp, error) {
+	return db.setEngagement(engagementLike, chirpID, userID, true)
+}
+
+func (db *DB) UnlikeChirp(chirpID, userID int) (Chirp, error) {
+	return db.setEngagement(engagementLike, chirpID, userID, false)
+}
+
+func (db *DB) Rechirp(chirpID, userID int) (Chirp, error) {
+	return db.setEngagement(engagementRechirp, chirpID, userID, true)
+}
+
+func (db *DB) Unrechirp(chirpID, userID int) (Chirp, error) {
+	return db.setEngagement(engagementRechirp, chirpID, userID, false)
+}
+
+// setEngagement adds or removes userID from the likes or rechirps of a chirp
+// and adjusts the matching counter. Repeating an action is a no-op, so the
+// counter always equals the number of users. Holding txMu for the whole
+// read-modify-write keeps counters correct under concurrent requests.
+func (db *DB) setEngagement(kind string, chirpID, userID int, on bool) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if on && !dbStructure.canView(userID, chirp) {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	// rechirping would show followers-only and direct chirps to people
+	// they weren't meant for
+	if on && kind == engagementRechirp && chirp.Author != userID &&
+		chirp.Visibility != VisibilityPublic && chirp.Visibility != VisibilityUnlisted {
+		return Chirp{}, errors.New("cannot rechirp")
+	}
+
+	set := dbStructure.Likes
+	counter := &chirp.LikeCount
+	if kind == engagementRechirp {
+		set = dbStructure.Rechirps
+		counter = &chirp.RechirpCount
+	}
+	_, already := set[chirpID][userID]
+	if on == already {
+		return chirp, nil
+	}
+
+	if on {
+		now := time.Now().UnixNano()
+		if set[chirpID] == nil {
+			set[chirpID] = map[int]int64{}
+		}
+		set[chirpID][userID] = now
+		*counter++
+		if kind == engagementLike {
+			if dbStructure.UserLikes[userID] == nil {
+				dbStructure.UserLikes[userID] = map[int]int64{}
+			}
+			dbStructure.UserLikes[userID][chirpID] = now
+		}
+	} else {
+		delete(set[chirpID], userID)
+		if len(set[chirpID]) == 0 {
+			delete(set, chirpID)
+		}
+		*counter--
+		if kind == engagementLike {
+			delete(dbStructure.UserLikes[userID], chirpID)
+		}
+	}
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// removeEngagement forgets who liked or rechirped a deleted chirp.
+func (dbStructure *DBStructure) removeEngagement(chirp Chirp) {
+	for userID := range dbStructure.Likes[chirp.ID] {
+		delete(dbStructure.UserLikes[userID], chirp.ID)
+	}
+	delete(dbStructure.Likes, chirp.ID)
+	delete(dbStructure.Rechirps, chirp.ID)
+	delete(dbStructure.PollVotes, chirp.ID)
+	if chirp.QuoteOfID != 0 {
+		if quoted, ok := dbStructure.Chirps[chirp.QuoteOfID]; ok && quoted.QuoteCount > 0 {
+			quoted.QuoteCount--
+			dbStructure.Chirps[quoted.ID] = quoted
+		}
+	}
+}
+
+// GetViewerStates reports, for each of the chirps, whether viewerID liked,
+// rechirped or bookmarked it, which of their keyword filters it matches and
+// how they voted in its poll.
+func (db *DB) GetViewerStates(viewerID int, chirpIDs []int) (map[int]ViewerState, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	view := dbStructure.viewFilterFor(viewerID)
+	states := map[int]ViewerState{}
+	for _, id := range chirpIDs {
+		_, liked := dbStructure.Likes[id][viewerID]
+		_, rechirped := dbStructure.Rechirps[id][viewerID]
+		_, bookmarked := dbStructure.Bookmarks[viewerID][id]
+		state := ViewerState{
+			Liked:      liked,
+			Rechirped:  rechirped,
+			Bookmarked: bookmarked,
+			Filtered:   view.matches(dbStructure.Chirps[id]),
+		}
+		if option, voted := dbStructure.PollVotes[id][viewerID]; voted {
+			state.PollVote = &option
+		}
+		states[id] = state
+	}
+	return states, nil
+}
+
+// ListLikedChirps returns the chirps userID has liked, most recently liked
+// first, leaving out those hidden from viewerID.
+func (db *DB) ListLikedChirps(userID, viewerID int, q PageQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	view := dbStructure.viewFilterFor(viewerID)
+	items := []PageKey{}
+	for chirpID, likedAt := range dbStructure.UserLikes[userID] {
+		if chirp, ok := dbStructure.Chirps[chirpID]; ok && !chirp.Deleted && !view.hides(chirp) {
+			items = append(items, PageKey{ID: chirpID, Key: likedAt})
+		}
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
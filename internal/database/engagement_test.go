@@ -0,0 +1,56 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentEngagement(t *testing.T) {
+	const users = 20
+	tests := []struct {
+		name string
+		// each user in likers likes the chirp twice and each user in
+		// unlikers then unlikes it, all at once
+		likers, unlikers int
+		wantLikes        int
+	}{
+		{name: "likes", likers: users, wantLikes: users},
+		{name: "likes and unlikes", likers: users, unlikers: users / 2, wantLikes: users - users/2},
+		{name: "unlikes of nothing", unlikers: users, wantLikes: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, users)
+			chirp, err := db.CreateChirp("like me", 1, ChirpOptions{})
+			if err != nil {
+				t.Fatalf("CreateChirp: %v", err)
+			}
+
+			run := func(n int, action func(chirpID, userID int) (Chirp, error)) {
+				var wg sync.WaitGroup
+				for userID := 1; userID <= n; userID++ {
+					wg.Add(1)
+					go func(userID int) {
+						defer wg.Done()
+						if _, err := action(chirp.ID, userID); err != nil {
+							t.Errorf("user %d: %v", userID, err)
+						}
+					}(userID)
+				}
+				wg.Wait()
+			}
+			run(tt.likers, db.LikeChirp)
+			run(tt.likers, db.LikeChirp)
+			run(tt.unlikers, db.UnlikeChirp)
+
+			got, err := db.GetChirp(strconv.Itoa(chirp.ID))
+			if err != nil {
+				t.Fatalf("GetChirp: %v", err)
+			}
+			if got.LikeCount != tt.wantLikes {
+				t.Errorf("LikeCount = %d, want %d", got.LikeCount, tt.wantLikes)
+			}
+		})
+	}
+}
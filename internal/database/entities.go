@@ -0,0 +1,59 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChirpEntities are the structured parts found in a chirp body. Offsets are
+// in characters (runes), not bytes, with End exclusive.
+type ChirpEntities struct {
+	Hashtags []HashtagEntity `json:"hashtags"`
+	Mentions []MentionEntity `json:"mentions"`
+}
+
+type HashtagEntity struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// a hashtag can't follow a word character (so "C#" or "a#b" don't count)
+// and must contain at least one letter
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_&#])(#[\p{L}\p{M}\p{N}_]*\p{L}[\p{L}\p{M}\p{N}_]*)`)
+
+// NormalizeHashtag returns the form hashtags are indexed under.
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func (dbStructure *DBStructure) extractEntities(body string) ChirpEntities {
+	entities := ChirpEntities{
+		Hashtags: []HashtagEntity{},
+		Mentions: dbStructure.extractMentions(body),
+	}
+	for _, m := range hashtagPattern.FindAllStringSubmatchIndex(body, -1) {
+		start := utf8.RuneCountInString(body[:m[2]])
+		end := start + utf8.RuneCountInString(body[m[2]:m[3]])
+		entities.Hashtags = append(entities.Hashtags, HashtagEntity{
+			Tag:   NormalizeHashtag(body[m[2]:m[3]]),
+			Start: start,
+			End:   end,
+		})
+	}
+	return entities
+}
+
+// Tags returns the distinct hashtags of the chirp.
+func (e ChirpEntities) Tags() []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, hashtag := range e.Hashtags {
+		if !seen[hashtag.Tag] {
+			seen[hashtag.Tag] = true
+			tags = append(tags, hashtag.Tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	FilterActionHide     = "hide"
+	FilterActionCollapse = "collapse"
+)
+
+// KeywordFilter is a word, phrase or pattern a user doesn't want to see.
+// Chirps matching a hide filter are left out of lists, while chirps matching
+// a collapse filter are returned with a marker so clients can fold them.
+type KeywordFilter struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id"`
+	Phrase        string     `json:"phrase"`
+	Regex         bool       `json:"regex"`
+	WholeWord     bool       `json:"whole_word"`
+	CaseSensitive bool       `json:"case_sensitive"`
+	Action        string     `json:"action"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// FilterMatch identifies a keyword filter a chirp matched.
+type FilterMatch struct {
+	ID     int    `json:"id"`
+	Phrase string `json:"phrase"`
+	Action string `json:"action"`
+}
+
+// Pattern compiles the filter into a regular expression over chirp bodies.
+func (f KeywordFilter) Pattern() (*regexp.Regexp, error) {
+	expr := f.Phrase
+	if !f.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if f.WholeWord {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if !f.CaseSensitive {
+		expr = `(?i)` + expr
+	}
+	return regexp.Compile(expr)
+}
+
+func (f KeywordFilter) expired(now time.Time) bool {
+	return f.ExpiresAt != nil && !f.ExpiresAt.After(now)
+}
+
+func (db *DB) CreateKeywordFilter(filter KeywordFilter) (KeywordFilter, error) {
+	if filter.Phrase == "" {
+		return KeywordFilter{}, errors.New("empty phrase")
+	}
+	if filter.Action != FilterActionHide && filter.Action != FilterActionCollapse {
+		return KeywordFilter{}, errors.New("unknown filter action")
+	}
+	if _, err := filter.Pattern(); err != nil {
+		return KeywordFilter{}, errors.New("invalid pattern")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return KeywordFilter{}, err
+	}
+	now := time.Now().UTC()
+	id := 1
+	for filterID, other := range dbStructure.KeywordFilters {
+		if other.expired(now) {
+			delete(dbStructure.KeywordFilters, filterID)
+		}
+		if filterID >= id {
+			id = filterID + 1
+		}
+	}
+	filter.ID = id
+	filter.CreatedAt = now
+	dbStructure.KeywordFilters[id] = filter
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return KeywordFilter{}, err
+	}
+	return filter, nil
+}
+
+// GetKeywordFilters returns the filters of userID that haven't expired.
+func (db *DB) GetKeywordFilters(userID int) ([]KeywordFilter, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	filters := dbStructure.activeKeywordFilters(userID)
+	sort.Slice(filters, func(i, j int) bool {
+		return filters[i].ID < filters[j].ID
+	})
+	return filters, nil
+}
+
+func (db *DB) DeleteKeywordFilter(id, userID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	filter, ok := dbStructure.KeywordFilters[id]
+	if !ok || filter.UserID != userID {
+		return errors.New("filter not found")
+	}
+	delete(dbStructure.KeywordFilters, id)
+	return db.writeDB(dbStructure)
+}
+
+func (dbStructure *DBStructure) activeKeywordFilters(userID int) []KeywordFilter {
+	now := time.Now()
+	filters := []KeywordFilter{}
+	for _, filter := range dbStructure.KeywordFilters {
+		if filter.UserID == userID && !filter.expired(now) {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+type compiledFilter struct {
+	KeywordFilter
+	pattern *regexp.Regexp
+}
+
+// viewFilter decides which chirps a viewer gets to see, from the chirps'
+// visibility and the viewer's blocks, mutes and keyword filters.
+type viewFilter struct {
+	viewerID      int
+	following     map[int]int64
+	hiddenAuthors map[int]bool
+	keywords      []compiledFilter
+}
+
+// viewFilterFor builds the view filter of viewerID. Anonymous viewers, with
+// an ID of 0, see every chirp that isn't limited to followers or mentioned
+// users.
+func (dbStructure *DBStructure) viewFilterFor(viewerID int) viewFilter {
+	view := viewFilter{
+		viewerID:      viewerID,
+		following:     dbStructure.Following[viewerID],
+		hiddenAuthors: dbStructure.hiddenAuthors(viewerID),
+	}
+	if viewerID == 0 {
+		return view
+	}
+	for _, filter := range dbStructure.activeKeywordFilters(viewerID) {
+		pattern, err := filter.Pattern()
+		if err != nil {
+			continue
+		}
+		view.keywords = append(view.keywords, compiledFilter{KeywordFilter: filter, pattern: pattern})
+	}
+	sort.Slice(view.keywords, func(i, j int) bool {
+		return view.keywords[i].ID < view.keywords[j].ID
+	})
+	return view
+}
+
+// hides reports whether chirp should be left out of the viewer's lists.
+func (view viewFilter) hides(chirp Chirp) bool {
+	if view.hiddenAuthors[chirp.Author] || !view.canSee(chirp) {
+		return true
+	}
+	for _, filter := range view.keywords {
+		if filter.Action == FilterActionHide && filter.pattern.MatchString(chirp.Body) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns the keyword filters chirp matches.
+func (view viewFilter) matches(chirp Chirp) []FilterMatch {
+	matches := []FilterMatch{}
+	for _, filter := range view.keywords {
+		if filter.pattern.MatchString(chirp.Body) {
+			matches = append(matches, FilterMatch{
+				ID:     filter.ID,
+				Phrase: filter.Phrase,
+				Action: filter.Action,
+			})
+		}
+	}
+	return matches
+}
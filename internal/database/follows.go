@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// FanOutOnRead builds a timeline when it's requested by merging the
+	// chirps of every followed account.
+	FanOutOnRead = "read"
+	// FanOutOnWrite pushes each new chirp into the stored timeline of every
+	// follower of its author, so reading a timeline is a single lookup.
+	FanOutOnWrite = "write"
+)
+
+type FollowCounts struct {
+	Followers int `json:"follower_count"`
+	Following int `json:"following_count"`
+}
+
+// Follow makes followerID follow followeeID. Following someone twice is a
+// no-op.
+func (db *DB) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return errors.New("cannot follow yourself")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[followeeID]; !ok {
+		return errors.New("user not found")
+	}
+	if dbStructure.isBlocked(followerID, followeeID) {
+		return errors.New("blocked")
+	}
+	if _, ok := dbStructure.Following[followerID][followeeID]; ok {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	if dbStructure.Following[followerID] == nil {
+		dbStructure.Following[followerID] = map[int]int64{}
+	}
+	dbStructure.Following[followerID][followeeID] = now
+	if dbStructure.Followers[followeeID] == nil {
+		dbStructure.Followers[followeeID] = map[int]int64{}
+	}
+	dbStructure.Followers[followeeID][followerID] = now
+
+	if dbStructure.TimelineMode == FanOutOnWrite {
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.Author == followeeID && !chirp.Deleted {
+				dbStructure.pushToTimeline(followerID, chirp)
+			}
+		}
+	}
+	return db.writeDB(dbStructure)
+}
+
+// Unfollow makes followerID stop following followeeID.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.Users[followeeID]; !ok {
+		return errors.New("user not found")
+	}
+	if _, ok := dbStructure.Following[followerID][followeeID]; !ok {
+		return nil
+	}
+
+	dbStructure.removeFollow(followerID, followeeID)
+	return db.writeDB(dbStructure)
+}
+
+// removeFollow deletes the follow edge and, when timelines are built on
+// write, the followee's chirps from the follower's timeline.
+func (dbStructure *DBStructure) removeFollow(followerID, followeeID int) {
+	delete(dbStructure.Following[followerID], followeeID)
+	if len(dbStructure.Following[followerID]) == 0 {
+		delete(dbStructure.Following, followerID)
+	}
+	delete(dbStructure.Followers[followeeID], followerID)
+	if len(dbStructure.Followers[followeeID]) == 0 {
+		delete(dbStructure.Followers, followeeID)
+	}
+
+	if dbStructure.TimelineMode == FanOutOnWrite {
+		for chirpID := range dbStructure.Timelines[followerID] {
+			if dbStructure.Chirps[chirpID].Author == followeeID {
+				delete(dbStructure.Timelines[followerID], chirpID)
+			}
+		}
+	}
+}
+
+func (db *DB) GetFollowCounts(userID int) (FollowCounts, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return FollowCounts{}, err
+	}
+	return FollowCounts{
+		Followers: len(dbStructure.Followers[userID]),
+		Following: len(dbStructure.Following[userID]),
+	}, nil
+}
+
+// ListFollowers returns the users following userID, most recent first.
+func (db *DB) ListFollowers(userID int, q PageQuery) (UserPage, error) {
+	return db.listRelations(func(dbStructure DBStructure) map[int]int64 {
+		return dbStructure.Followers[userID]
+	}, q)
+}
+
+// ListFollowing returns the users userID follows, most recent first.
+func (db *DB) ListFollowing(userID int, q PageQuery) (UserPage, error) {
+	return db.listRelations(func(dbStructure DBStructure) map[int]int64 {
+		return dbStructure.Following[userID]
+	}, q)
+}
+
+// listRelations pages through the users at the other end of edges, most
+// recently added first. Only the public parts of each user are returned.
+func (db *DB) listRelations(edges func(DBStructure) map[int]int64, q PageQuery) (UserPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	items := []PageKey{}
+	for id, followedAt := range edges(dbStructure) {
+		items = append(items, PageKey{ID: id, Key: followedAt})
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := UserPage{
+		Users: make([]User, 0, len(window)),
+		Next:  next,
+		Prev:  prev,
+	}
+	for _, item := range window {
+		page.Users = append(page.Users, dbStructure.Users[item.ID].public())
+	}
+	return page, nil
+}
+
+// pushToTimeline adds chirp to the stored timeline of userID.
+func (dbStructure *DBStructure) pushToTimeline(userID int, chirp Chirp) {
+	if dbStructure.Timelines[userID] == nil {
+		dbStructure.Timelines[userID] = map[int]int64{}
+	}
+	dbStructure.Timelines[userID][chirp.ID] = chirp.CreatedAt.UnixNano()
+}
+
+// fanOutChirp delivers a new chirp to its author's and followers' stored
+// timelines. It does nothing when timelines are built on read.
+func (dbStructure *DBStructure) fanOutChirp(chirp Chirp) {
+	if dbStructure.TimelineMode != FanOutOnWrite {
+		return
+	}
+	dbStructure.pushToTimeline(chirp.Author, chirp)
+	for followerID := range dbStructure.Followers[chirp.Author] {
+		dbStructure.pushToTimeline(followerID, chirp)
+	}
+}
+
+// unfanOutChirp takes a deleted chirp back out of the stored timelines.
+func (dbStructure *DBStructure) unfanOutChirp(chirp Chirp) {
+	delete(dbStructure.Timelines[chirp.Author], chirp.ID)
+	for followerID := range dbStructure.Followers[chirp.Author] {
+		delete(dbStructure.Timelines[followerID], chirp.ID)
+	}
+}
+
+// SetTimelineMode switches between FanOutOnRead and FanOutOnWrite. Stored
+// timelines aren't kept up to date while building on read, so switching to
+// FanOutOnWrite rebuilds all of them.
+func (db *DB) SetTimelineMode(mode string) error {
+	if mode != FanOutOnRead && mode != FanOutOnWrite {
+		return errors.New("unknown timeline mode")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if dbStructure.TimelineMode == mode {
+		return nil
+	}
+	dbStructure.TimelineMode = mode
+	dbStructure.Timelines = map[int]map[int]int64{}
+	if mode == FanOutOnWrite {
+		for _, chirp := range dbStructure.Chirps {
+			if !chirp.Deleted {
+				dbStructure.fanOutChirp(chirp)
+			}
+		}
+	}
+	return db.writeDB(dbStructure)
+}
+
+// GetTimeline returns the chirps of userID and the accounts they follow,
+// newest first. Chirps hidden from userID are left out.
+func (db *DB) GetTimeline(userID int, q PageQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	view := dbStructure.viewFilterFor(userID)
+	items := []PageKey{}
+	if dbStructure.TimelineMode == FanOutOnWrite {
+		for chirpID, createdAt := range dbStructure.Timelines[userID] {
+			chirp, ok := dbStructure.Chirps[chirpID]
+			if ok && !chirp.Deleted && !view.hides(chirp) {
+				items = append(items, PageKey{ID: chirpID, Key: createdAt})
+			}
+		}
+	} else {
+		authors := map[int]bool{userID: true}
+		for followeeID := range dbStructure.Following[userID] {
+			authors[followeeID] = true
+		}
+		for _, chirp := range dbStructure.Chirps {
+			if authors[chirp.Author] && !chirp.Deleted && !view.hides(chirp) {
+				items = append(items, PageKey{ID: chirp.ID, Key: chirp.CreatedAt.UnixNano()})
+			}
+		}
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// defaultHandle gives a new user a neutral handle based on their ID, like
+// user42, adding a number if someone already took it. It must not be derived
+// from the email address, since handles are public.
+func (dbStructure *DBStructure) defaultHandle(userID int) string {
+	base := "user" + strconv.Itoa(userID)
+	handle := base
+	for n := 2; dbStructure.userByHandle(handle) != nil; n++ {
+		handle = base + "_" + strconv.Itoa(n)
+	}
+	return handle
+}
+
+// userByHandle looks a user up by handle, ignoring case.
+func (dbStructure *DBStructure) userByHandle(handle string) *User {
+	handle = strings.TrimPrefix(handle, "@")
+	for _, user := range dbStructure.Users {
+		if strings.EqualFold(user.Handle, handle) {
+			return &user
+		}
+	}
+	return nil
+}
+
+// ensureHandles gives a handle to users created before handles existed.
+func (db *DB) ensureHandles() error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for id := 1; id <= maxUserID(dbStructure); id++ {
+		user, ok := dbStructure.Users[id]
+		if !ok || user.Handle != "" {
+			continue
+		}
+		user.Handle = dbStructure.defaultHandle(user.ID)
+		dbStructure.Users[id] = user
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return db.writeDB(dbStructure)
+}
+
+func maxUserID(dbStructure DBStructure) int {
+	max := 0
+	for id := range dbStructure.Users {
+		if id > max {
+			max = id
+		}
+	}
+	return max
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// hashtagIndexVersion is bumped whenever entity extraction changes, which
+// makes NewDB extract the entities of every stored chirp again.
+const hashtagIndexVersion = 2
+
+// indexHashtags adds chirp to the index of every hashtag it uses. The index
+// keeps each chirp's creation time so trends don't need to load chirps. Only
+// public chirps are indexed, so hashtag lists and trends never reveal the
+// others.
+func (dbStructure *DBStructure) indexHashtags(chirp Chirp) {
+	if chirp.Visibility != "" && chirp.Visibility != VisibilityPublic {
+		return
+	}
+	for _, tag := range chirp.Entities.Tags() {
+		chirps, ok := dbStructure.Hashtags[tag]
+		if !ok {
+			chirps = map[int]int64{}
+			dbStructure.Hashtags[tag] = chirps
+		}
+		chirps[chirp.ID] = chirp.CreatedAt.UnixNano()
+	}
+}
+
+func (dbStructure *DBStructure) unindexHashtags(chirp Chirp) {
+	for _, tag := range chirp.Entities.Tags() {
+		delete(dbStructure.Hashtags[tag], chirp.ID)
+		if len(dbStructure.Hashtags[tag]) == 0 {
+			delete(dbStructure.Hashtags, tag)
+		}
+	}
+}
+
+// RebuildHashtagIndex extracts the entities of every stored chirp again and
+// rebuilds the hashtag index from them. Mentions found this way are recorded
+// as already read, since they aren't new.
+func (db *DB) RebuildHashtagIndex() error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	dbStructure.Hashtags = map[string]map[int]int64{}
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.Deleted {
+			continue
+		}
+		chirp.Entities = dbStructure.extractEntities(chirp.Body)
+		dbStructure.Chirps[id] = chirp
+		dbStructure.indexHashtags(chirp)
+		dbStructure.recordMentions(chirp, true)
+	}
+	dbStructure.HashtagIndexVersion = hashtagIndexVersion
+	return db.writeDB(dbStructure)
+}
+
+func (db *DB) ensureHashtagIndex() error {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if dbStructure.HashtagIndexVersion == hashtagIndexVersion {
+		return nil
+	}
+	return db.RebuildHashtagIndex()
+}
+
+type TrendingTag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+	Count int     `json:"count"`
+}
+
+// TrendingHashtags ranks the hashtags used within window. Each use counts
+// for less the older it is, halving every quarter of the window, so recent
+// bursts outrank steady but old activity.
+func (db *DB) TrendingHashtags(window time.Duration, limit int) ([]TrendingTag, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	since := now.Add(-window).UnixNano()
+	halfLife := float64(window) / 4
+	trends := []TrendingTag{}
+	for tag, chirps := range dbStructure.Hashtags {
+		trend := TrendingTag{Tag: tag}
+		for _, createdAt := range chirps {
+			if createdAt < since {
+				continue
+			}
+			age := float64(now.UnixNano() - createdAt)
+			trend.Score += math.Pow(0.5, age/halfLife)
+			trend.Count++
+		}
+		if trend.Count > 0 {
+			trend.Score = math.Round(trend.Score*1e4) / 1e4
+			trends = append(trends, trend)
+		}
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Score != trends[j].Score {
+			return trends[i].Score > trends[j].Score
+		}
+		return trends[i].Tag < trends[j].Tag
+	})
+	if len(trends) > limit {
+		trends = trends[:limit]
+	}
+	return trends, nil
+}
+
+type HashtagBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+type HashtagAnalytics struct {
+	Tag           string          `json:"tag"`
+	Since         time.Time       `json:"since"`
+	Until         time.Time       `json:"until"`
+	Total         int             `json:"total"`
+	UniqueAuthors int             `json:"unique_authors"`
+	Series        []HashtagBucket `json:"series"`
+}
+
+// GetHashtagAnalytics counts the uses of tag over window, split into buckets
+// of the given size, oldest first.
+func (db *DB) GetHashtagAnalytics(tag string, window, bucket time.Duration) (HashtagAnalytics, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return HashtagAnalytics{}, err
+	}
+
+	until := time.Now().UTC()
+	since := until.Add(-window).Truncate(bucket)
+	analytics := HashtagAnalytics{
+		Tag:    NormalizeHashtag(tag),
+		Since:  since,
+		Until:  until,
+		Series: []HashtagBucket{},
+	}
+	for start := since; start.Before(until); start = start.Add(bucket) {
+		analytics.Series = append(analytics.Series, HashtagBucket{Start: start})
+	}
+
+	authors := map[int]bool{}
+	for id, createdAt := range dbStructure.Hashtags[analytics.Tag] {
+		i := int((createdAt - since.UnixNano()) / int64(bucket))
+		if i < 0 || i >= len(analytics.Series) {
+			continue
+		}
+		analytics.Series[i].Count++
+		analytics.Total++
+		authors[dbStructure.Chirps[id].Author] = true
+	}
+	analytics.UniqueAuthors = len(authors)
+	return analytics, nil
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB returns a database in a temporary directory with n users, whose
+// IDs are 1 to n.
+func newTestDB(t *testing.T, n int) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	for i := 1; i <= n; i++ {
+		_, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's issuer and subject.
+type Identity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin holds the state of an authorization code flow between the
+// redirect to the provider and the callback.
+type OIDCLogin struct {
+	Verifier  string `json:"verifier"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (db *DB) SaveOIDCLogin(state string, login OIDCLogin) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for s, l := range dbStructure.OIDCLogins {
+		if l.ExpiresAt < now {
+			delete(dbStructure.OIDCLogins, s)
+		}
+	}
+	dbStructure.OIDCLogins[state] = login
+	return db.writeDB(dbStructure)
+}
+
+// TakeOIDCLogin returns the pending login for state and removes it, so each
+// state can only complete one callback.
+func (db *DB) TakeOIDCLogin(state string) (OIDCLogin, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	login, ok := dbStructure.OIDCLogins[state]
+	if !ok {
+		return OIDCLogin{}, errors.New("login state not found")
+	}
+	delete(dbStructure.OIDCLogins, state)
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	if login.ExpiresAt < time.Now().Unix() {
+		return OIDCLogin{}, errors.New("login state not found")
+	}
+	return login, nil
+}
+
+func (db *DB) GetIdentity(issuer, subject string) (Identity, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Identity{}, err
+	}
+	for _, identity := range dbStructure.Identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return Identity{}, errors.New("identity not found")
+}
+
+func (db *DB) LinkIdentity(userID int, issuer, subject, email string) (Identity, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Identity{}, err
+	}
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return Identity{}, errors.New("user not found")
+	}
+	for _, identity := range dbStructure.Identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return Identity{}, errors.New("identity already linked")
+		}
+	}
+
+	id := 1
+	for identityID := range dbStructure.Identities {
+		if identityID >= id {
+			id = identityID + 1
+		}
+	}
+	identity := Identity{
+		ID:        id,
+		UserID:    userID,
+		Issuer:    issuer,
+		Subject:   subject,
+		Email:     strings.ToLower(email),
+		CreatedAt: time.Now().UTC(),
+	}
+	dbStructure.Identities[id] = identity
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Identity{}, err
+	}
+	return identity, nil
+}
+
+func (db *DB) GetUserIdentities(userID int) ([]Identity, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	identities := []Identity{}
+	for _, identity := range dbStructure.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].ID < identities[j].ID
+	})
+	return identities, nil
+}
+
+// DeleteIdentity unlinks an identity from its user. It refuses to remove the
+// last identity of a user without a password, since they'd be locked out.
+func (db *DB) DeleteIdentity(id, userID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	identity, ok := dbStructure.Identities[id]
+	if !ok || identity.UserID != userID {
+		return errors.New("identity not found")
+	}
+	if dbStructure.Users[userID].Password == "" {
+		linked := 0
+		for _, other := range dbStructure.Identities {
+			if other.UserID == userID {
+				linked++
+			}
+		}
+		if linked == 1 {
+			return errors.New("last login method")
+		}
+	}
+	delete(dbStructure.Identities, id)
+	return db.writeDB(dbStructure)
+}
@@ -0,0 +1,301 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxListNameLength        = 50
+	MaxListDescriptionLength = 200
+	MaxListMembers           = 1000
+)
+
+// List is a named group of accounts curated by its owner, with a timeline
+// of its members' chirps. Anyone can read a public list; a private one is
+// only visible to its owner.
+type List struct {
+	ID          int       `json:"id"`
+	OwnerID     int       `json:"owner_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Private     bool      `json:"private"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func checkList(list List) error {
+	name := strings.TrimSpace(list.Name)
+	if name == "" || utf8.RuneCountInString(name) > MaxListNameLength {
+		return errors.New("invalid list name")
+	}
+	if utf8.RuneCountInString(list.Description) > MaxListDescriptionLength {
+		return errors.New("list description too long")
+	}
+	return nil
+}
+
+// canSee reports whether viewerID may read the list.
+func (list List) canSee(viewerID int) bool {
+	return !list.Private || (viewerID != 0 && list.OwnerID == viewerID)
+}
+
+func (db *DB) CreateList(list List) (List, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	err = checkList(list)
+	if err != nil {
+		return List{}, err
+	}
+
+	// list ids are never reused, so an old link to a deleted public list
+	// can't lead to somebody else's new one
+	id := dbStructure.LastListID + 1
+	for listID := range dbStructure.Lists {
+		if listID >= id {
+			id = listID + 1
+		}
+	}
+	dbStructure.LastListID = id
+	now := time.Now().UTC()
+	list.ID = id
+	list.Name = strings.TrimSpace(list.Name)
+	list.MemberCount = 0
+	list.CreatedAt = now
+	list.UpdatedAt = now
+	dbStructure.Lists[id] = list
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// UpdateList changes the name, description and privacy of one of the
+// owner's lists.
+func (db *DB) UpdateList(list List) (List, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	existing, ok := dbStructure.Lists[list.ID]
+	if !ok || existing.OwnerID != list.OwnerID {
+		return List{}, errors.New("list not found")
+	}
+	err = checkList(list)
+	if err != nil {
+		return List{}, err
+	}
+
+	existing.Name = strings.TrimSpace(list.Name)
+	existing.Description = list.Description
+	existing.Private = list.Private
+	existing.UpdatedAt = time.Now().UTC()
+	dbStructure.Lists[list.ID] = existing
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return existing, nil
+}
+
+func (db *DB) DeleteList(id, ownerID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	list, ok := dbStructure.Lists[id]
+	if !ok || list.OwnerID != ownerID {
+		return errors.New("list not found")
+	}
+	delete(dbStructure.Lists, id)
+	delete(dbStructure.ListMembers, id)
+	return db.writeDB(dbStructure)
+}
+
+// GetList returns a list viewerID may see. Private lists of other users look
+// like they don't exist.
+func (db *DB) GetList(id, viewerID int) (List, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	list, ok := dbStructure.Lists[id]
+	if !ok || !list.canSee(viewerID) {
+		return List{}, errors.New("list not found")
+	}
+	return list, nil
+}
+
+// GetLists returns the lists ownerID made that viewerID may see, most
+// recently created first.
+func (db *DB) GetLists(ownerID, viewerID int) ([]List, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	lists := []List{}
+	for _, list := range dbStructure.Lists {
+		if list.OwnerID == ownerID && list.canSee(viewerID) {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID > lists[j].ID
+	})
+	return lists, nil
+}
+
+// AddListMembers adds users to one of the owner's lists. Either all of them
+// are added or, if any can't be, none are. Users already on the list are
+// skipped.
+func (db *DB) AddListMembers(id, ownerID int, userIDs []int) (List, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	list, ok := dbStructure.Lists[id]
+	if !ok || list.OwnerID != ownerID {
+		return List{}, errors.New("list not found")
+	}
+	members := dbStructure.ListMembers[id]
+	if members == nil {
+		members = map[int]int64{}
+	}
+	added := map[int]bool{}
+	for _, userID := range userIDs {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return List{}, errors.New("user not found")
+		}
+		if dbStructure.isBlocked(ownerID, userID) {
+			return List{}, errors.New("blocked")
+		}
+		if _, member := members[userID]; !member {
+			added[userID] = true
+		}
+	}
+	if len(members)+len(added) > MaxListMembers {
+		return List{}, errors.New("too many members")
+	}
+	if len(added) == 0 {
+		return list, nil
+	}
+
+	now := time.Now().UTC()
+	for userID := range added {
+		members[userID] = now.UnixNano()
+	}
+	dbStructure.ListMembers[id] = members
+	list.MemberCount = len(members)
+	list.UpdatedAt = now
+	dbStructure.Lists[id] = list
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// RemoveListMembers removes users from one of the owner's lists. Users who
+// aren't on it are skipped.
+func (db *DB) RemoveListMembers(id, ownerID int, userIDs []int) (List, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return List{}, err
+	}
+	list, ok := dbStructure.Lists[id]
+	if !ok || list.OwnerID != ownerID {
+		return List{}, errors.New("list not found")
+	}
+	members := dbStructure.ListMembers[id]
+	removed := false
+	for _, userID := range userIDs {
+		if _, member := members[userID]; member {
+			delete(members, userID)
+			removed = true
+		}
+	}
+	if !removed {
+		return list, nil
+	}
+	list.MemberCount = len(members)
+	list.UpdatedAt = time.Now().UTC()
+	dbStructure.Lists[id] = list
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return List{}, err
+	}
+	return list, nil
+}
+
+// ListListMembers returns the members of a list viewerID may see, most
+// recently added first.
+func (db *DB) ListListMembers(id, viewerID int, q PageQuery) (UserPage, error) {
+	list, err := db.GetList(id, viewerID)
+	if err != nil {
+		return UserPage{}, err
+	}
+	return db.listRelations(func(dbStructure DBStructure) map[int]int64 {
+		return dbStructure.ListMembers[list.ID]
+	}, q)
+}
+
+// GetListTimeline returns the chirps of a list's members, newest first,
+// leaving out those hidden from viewerID the same way the home timeline
+// does.
+func (db *DB) GetListTimeline(id, viewerID int, q PageQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	list, ok := dbStructure.Lists[id]
+	if !ok || !list.canSee(viewerID) {
+		return ChirpPage{}, errors.New("list not found")
+	}
+
+	view := dbStructure.viewFilterFor(viewerID)
+	members := dbStructure.ListMembers[id]
+	items := []PageKey{}
+	for _, chirp := range dbStructure.Chirps {
+		if _, member := members[chirp.Author]; member && !chirp.Deleted && !view.hides(chirp) {
+			items = append(items, PageKey{ID: chirp.ID, Key: chirp.CreatedAt.UnixNano()})
+		}
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
@@ -0,0 +1,29 @@
+package database
+
+import "testing"
+
+func TestListIDsAreNotReused(t *testing.T) {
+	db := newTestDB(t, 2)
+	first, err := db.CreateList(List{OwnerID: 1, Name: "first"})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	shared, err := db.CreateList(List{OwnerID: 1, Name: "shared"})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if err := db.DeleteList(shared.ID, 1); err != nil {
+		t.Fatalf("DeleteList: %v", err)
+	}
+
+	list, err := db.CreateList(List{OwnerID: 2, Name: "someone else's"})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if list.ID == shared.ID || list.ID == first.ID {
+		t.Errorf("new list got id %d, already used by %d and %d", list.ID, first.ID, shared.ID)
+	}
+	if _, err := db.GetList(shared.ID, 0); err == nil || err.Error() != "list not found" {
+		t.Errorf("GetList of the deleted list: %v, want list not found", err)
+	}
+}
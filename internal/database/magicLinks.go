@@ -0,0 +1,32 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ConsumeMagicLink marks the magic link identified by jti as used so it
+// can't be replayed. expiresAt is kept so the entry can be pruned once the
+// link could no longer be accepted anyway.
+func (db *DB) ConsumeMagicLink(jti string, expiresAt int64) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dbStructure.UsedMagicLinks[jti]; ok {
+		return errors.New("magic link already used")
+	}
+
+	now := time.Now().Unix()
+	for usedJti, exp := range dbStructure.UsedMagicLinks {
+		if exp < now {
+			delete(dbStructure.UsedMagicLinks, usedJti)
+		}
+	}
+	dbStructure.UsedMagicLinks[jti] = expiresAt
+
+	return db.writeDB(dbStructure)
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxAttachments is how many media a chirp can carry.
+	MaxAttachments = 4
+	// MaxAltTextLength is the longest alt text, in characters.
+	MaxAltTextLength = 1000
+)
+
+// MediaVariant is one stored rendition of an upload: the cleaned original
+// or one of its thumbnails.
+type MediaVariant struct {
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+}
+
+// Media is an uploaded image. Its bytes live in the blob store under the
+// keys of its variants; "original" is always present. ChirpID is the chirp
+// it's attached to, or 0 while it's unattached.
+type Media struct {
+	ID        int                     `json:"id"`
+	OwnerID   int                     `json:"owner_id"`
+	Variants  map[string]MediaVariant `json:"variants"`
+	ChirpID   int                     `json:"chirp_id,omitempty"`
+	CreatedAt time.Time               `json:"created_at"`
+}
+
+// Attachment is media attached to a chirp, along with its alt text.
+type Attachment struct {
+	MediaID     int      `json:"media_id"`
+	AltText     string   `json:"alt_text"`
+	ContentType string   `json:"content_type,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	Variants    []string `json:"variants,omitempty"`
+}
+
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	id := 1
+	for mediaID := range dbStructure.Media {
+		if mediaID >= id {
+			id = mediaID + 1
+		}
+	}
+	media.ID = id
+	media.ChirpID = 0
+	media.CreatedAt = time.Now().UTC()
+	dbStructure.Media[id] = media
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+// GetMedia returns media that viewerID may see: their own uploads, avatars,
+// and media attached to chirps they can read.
+func (db *DB) GetMedia(id, viewerID int) (Media, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	media, ok := dbStructure.Media[id]
+	if !ok {
+		return Media{}, errors.New("media not found")
+	}
+	if viewerID != 0 && media.OwnerID == viewerID {
+		return media, nil
+	}
+	if dbStructure.Users[media.OwnerID].AvatarMediaID == id {
+		return media, nil
+	}
+	chirp, ok := dbStructure.Chirps[media.ChirpID]
+	if media.ChirpID == 0 || !ok || chirp.Deleted || !dbStructure.canView(viewerID, chirp) {
+		return Media{}, errors.New("media not found")
+	}
+	return media, nil
+}
+
+// DeleteMedia removes one of the owner's uploads and returns it so its blobs
+// can be deleted too. Media attached to a chirp or used as the owner's
+// avatar can't be deleted; deleting the chirp or changing the avatar frees
+// it.
+func (db *DB) DeleteMedia(id, ownerID int) (Media, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	media, ok := dbStructure.Media[id]
+	if !ok || media.OwnerID != ownerID {
+		return Media{}, errors.New("media not found")
+	}
+	if media.ChirpID != 0 || dbStructure.Users[ownerID].AvatarMediaID == id {
+		return Media{}, errors.New("media attached")
+	}
+	delete(dbStructure.Media, id)
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+// checkAttachments makes sure a new chirp of author_id attaches at most
+// MaxAttachments of the author's own unattached uploads, each at most once.
+func (dbStructure *DBStructure) checkAttachments(author_id int, attachments []Attachment) error {
+	if len(attachments) > MaxAttachments {
+		return errors.New("too many attachments")
+	}
+	seen := map[int]bool{}
+	for _, attachment := range attachments {
+		media, ok := dbStructure.Media[attachment.MediaID]
+		if !ok || media.OwnerID != author_id || seen[media.ID] {
+			return errors.New("media not found")
+		}
+		if media.ChirpID != 0 {
+			return errors.New("media attached")
+		}
+		if utf8.RuneCountInString(attachment.AltText) > MaxAltTextLength {
+			return errors.New("alt text too long")
+		}
+		seen[media.ID] = true
+	}
+	return nil
+}
+
+// attachMedia attaches the media a new chirp refers to, filling in the
+// details of each attachment from its upload.
+func (dbStructure *DBStructure) attachMedia(chirp *Chirp, attachments []Attachment) {
+	chirp.Attachments = make([]Attachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		media := dbStructure.Media[attachment.MediaID]
+		media.ChirpID = chirp.ID
+		dbStructure.Media[media.ID] = media
+
+		original := media.Variants["original"]
+		variants := []string{}
+		for _, name := range []string{"small", "medium", "large"} {
+			if _, ok := media.Variants[name]; ok {
+				variants = append(variants, name)
+			}
+		}
+		chirp.Attachments = append(chirp.Attachments, Attachment{
+			MediaID:     media.ID,
+			AltText:     attachment.AltText,
+			ContentType: original.ContentType,
+			Width:       original.Width,
+			Height:      original.Height,
+			Variants:    variants,
+		})
+	}
+}
+
+// detachMedia releases the media of a removed chirp back to its owner, who
+// can then delete it or attach it to another chirp.
+func (dbStructure *DBStructure) detachMedia(chirp Chirp) {
+	for _, attachment := range chirp.Attachments {
+		media, ok := dbStructure.Media[attachment.MediaID]
+		if !ok || media.ChirpID != chirp.ID {
+			continue
+		}
+		media.ChirpID = 0
+		dbStructure.Media[media.ID] = media
+	}
+}
+
+// reattachMedia claims the media of a restored chirp again. Attachments whose
+// media its owner deleted or attached elsewhere in the meantime are dropped.
+func (dbStructure *DBStructure) reattachMedia(chirp *Chirp) {
+	var kept []Attachment
+	for _, attachment := range chirp.Attachments {
+		media, ok := dbStructure.Media[attachment.MediaID]
+		if !ok || media.OwnerID != chirp.Author || media.ChirpID != 0 {
+			continue
+		}
+		media.ChirpID = chirp.ID
+		dbStructure.Media[media.ID] = media
+		kept = append(kept, attachment)
+	}
+	chirp.Attachments = kept
+}
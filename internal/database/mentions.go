@@ -0,0 +1,164 @@
+package database
+
+import (
+	"regexp"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Mention records that a chirp mentioned a user, so the user can find it and
+// see how many mentions they haven't read yet.
+type Mention struct {
+	ChirpID   int       `json:"chirp_id"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read"`
+}
+
+type MentionEntity struct {
+	Handle string `json:"handle"`
+	UserID int    `json:"user_id"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+var mentionHandlePattern = regexp.MustCompile(`(?:^|[^\w@])(@\w{1,15})\b`)
+
+// extractMentions finds @handles in body that belong to a user.
+func (dbStructure *DBStructure) extractMentions(body string) []MentionEntity {
+	mentions := []MentionEntity{}
+	for _, m := range mentionHandlePattern.FindAllStringSubmatchIndex(body, -1) {
+		user := dbStructure.userByHandle(body[m[2]+1 : m[3]])
+		if user == nil {
+			continue
+		}
+		start := utf8.RuneCountInString(body[:m[2]])
+		mentions = append(mentions, MentionEntity{
+			Handle: user.Handle,
+			UserID: user.ID,
+			Start:  start,
+			End:    start + utf8.RuneCountInString(body[m[2]:m[3]]),
+		})
+	}
+	return mentions
+}
+
+// MentionedUserIDs returns the distinct users mentioned by the chirp.
+func (e ChirpEntities) MentionedUserIDs() []int {
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, mention := range e.Mentions {
+		if !seen[mention.UserID] {
+			seen[mention.UserID] = true
+			ids = append(ids, mention.UserID)
+		}
+	}
+	return ids
+}
+
+// recordMentions brings the mention records of chirp in line with its
+// entities. Existing records keep their read state, and authors mentioning
+// themselves or users they're blocked from aren't recorded.
+func (dbStructure *DBStructure) recordMentions(chirp Chirp, read bool) {
+	mentioned := map[int]bool{}
+	for _, userID := range chirp.Entities.MentionedUserIDs() {
+		if userID == chirp.Author || dbStructure.isBlocked(userID, chirp.Author) {
+			continue
+		}
+		mentioned[userID] = true
+		if _, ok := dbStructure.Mentions[userID][chirp.ID]; ok {
+			continue
+		}
+		if dbStructure.Mentions[userID] == nil {
+			dbStructure.Mentions[userID] = map[int]Mention{}
+		}
+		dbStructure.Mentions[userID][chirp.ID] = Mention{
+			ChirpID:   chirp.ID,
+			AuthorID:  chirp.Author,
+			CreatedAt: chirp.CreatedAt,
+			Read:      read,
+		}
+	}
+	for userID, mentions := range dbStructure.Mentions {
+		if _, ok := mentions[chirp.ID]; ok && !mentioned[userID] {
+			delete(mentions, chirp.ID)
+		}
+	}
+}
+
+func (dbStructure *DBStructure) removeMentions(chirp Chirp) {
+	for _, userID := range chirp.Entities.MentionedUserIDs() {
+		delete(dbStructure.Mentions[userID], chirp.ID)
+	}
+}
+
+// ListMentions returns the chirps mentioning userID, newest first.
+func (db *DB) ListMentions(userID int, q PageQuery) (ChirpPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	view := dbStructure.viewFilterFor(userID)
+	items := []PageKey{}
+	for chirpID := range dbStructure.Mentions[userID] {
+		if chirp, ok := dbStructure.Chirps[chirpID]; ok && !chirp.Deleted && !view.hides(chirp) {
+			items = append(items, PageKey{ID: chirpID, Key: int64(chirpID)})
+		}
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ChirpPage{
+		Chirps: make([]Chirp, 0, len(window)),
+		Next:   next,
+		Prev:   prev,
+	}
+	for _, item := range window {
+		page.Chirps = append(page.Chirps, dbStructure.Chirps[item.ID])
+	}
+	return page, nil
+}
+
+func (db *DB) CountUnreadMentions(userID int) (int, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	unread := 0
+	for _, mention := range dbStructure.Mentions[userID] {
+		if !mention.Read {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+// MarkMentionsRead marks the user's mentions as read, up to and including
+// chirp upToID. An upToID of 0 marks all of them.
+func (db *DB) MarkMentionsRead(userID, upToID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for chirpID, mention := range dbStructure.Mentions[userID] {
+		if !mention.Read && (upToID == 0 || chirpID <= upToID) {
+			ids = append(ids, chirpID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Ints(ids)
+	for _, chirpID := range ids {
+		mention := dbStructure.Mentions[userID][chirpID]
+		mention.Read = true
+		dbStructure.Mentions[userID][chirpID] = mention
+	}
+	return db.writeDB(dbStructure)
+}
@@ -0,0 +1,436 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	// DMsFromEveryone lets anyone who isn't blocked message the user.
+	DMsFromEveryone = "everyone"
+	// DMsFromFollowing only lets the accounts the user follows start a
+	// conversation with them.
+	DMsFromFollowing = "following"
+)
+
+// MaxConversationSize is the most participants a conversation can have,
+// including the user who started it.
+const MaxConversationSize = 10
+
+// Conversation is a private exchange of messages between its participants,
+// kept apart from chirps.
+type Conversation struct {
+	ID             int       `json:"id"`
+	ParticipantIDs []int     `json:"participant_ids"`
+	CreatedBy      int       `json:"created_by"`
+	LastMessageID  int       `json:"last_message_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type Message struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       int       `json:"sender_id"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ConversationMember is a participant's own view of a conversation: the
+// last message they read, and whether they deleted it. Messages up to
+// ClearedThrough, the last one when they deleted it, are hidden from them,
+// and a deleted conversation only shows up again once a newer message
+// arrives.
+type ConversationMember struct {
+	LastReadID     int  `json:"lastReadId"`
+	ClearedThrough int  `json:"clearedThrough"`
+	Deleted        bool `json:"deleted"`
+}
+
+// hides reports whether the member deleted the conversation and nothing was
+// said since.
+func (member ConversationMember) hides(conversation Conversation) bool {
+	return member.Deleted && conversation.LastMessageID <= member.ClearedThrough
+}
+
+// ConversationSummary is a conversation as listed for one participant.
+type ConversationSummary struct {
+	Conversation
+	LastMessage *Message `json:"last_message,omitempty"`
+	UnreadCount int      `json:"unread_count"`
+}
+
+type ConversationPage struct {
+	Conversations []ConversationSummary
+	Next          *PageKey
+	Prev          *PageKey
+}
+
+type MessagePage struct {
+	Messages []Message
+	Next     *PageKey
+	Prev     *PageKey
+}
+
+type UnreadMessageCounts struct {
+	Messages      int `json:"unread_messages"`
+	Conversations int `json:"unread_conversations"`
+}
+
+// SetDMPolicy sets who can start conversations with userID.
+func (db *DB) SetDMPolicy(userID int, policy string) error {
+	if policy != DMsFromEveryone && policy != DMsFromFollowing {
+		return errors.New("unknown dm policy")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return errors.New("user not found")
+	}
+	user.AllowDMsFrom = policy
+	dbStructure.Users[userID] = user
+	return db.writeDB(dbStructure)
+}
+
+// acceptsDMsFrom reports whether recipientID lets senderID message them.
+func (dbStructure *DBStructure) acceptsDMsFrom(recipientID, senderID int) bool {
+	if dbStructure.isBlocked(recipientID, senderID) {
+		return false
+	}
+	if dbStructure.Users[recipientID].AllowDMsFrom != DMsFromFollowing {
+		return true
+	}
+	_, follows := dbStructure.Following[recipientID][senderID]
+	return follows
+}
+
+// StartConversation opens a conversation between creatorID and the other
+// participants. Starting a one-to-one conversation that already exists
+// returns it instead of opening another. Every participant has to accept
+// messages from creatorID.
+func (db *DB) StartConversation(creatorID int, participantIDs []int) (Conversation, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	seen := map[int]bool{creatorID: true}
+	participants := []int{creatorID}
+	for _, id := range participantIDs {
+		if seen[id] {
+			continue
+		}
+		if _, ok := dbStructure.Users[id]; !ok {
+			return Conversation{}, errors.New("user not found")
+		}
+		seen[id] = true
+		participants = append(participants, id)
+	}
+	if len(participants) < 2 {
+		return Conversation{}, errors.New("no participants")
+	}
+	if len(participants) > MaxConversationSize {
+		return Conversation{}, errors.New("too many participants")
+	}
+	for _, id := range participants[1:] {
+		if dbStructure.isBlocked(creatorID, id) {
+			return Conversation{}, errors.New("blocked")
+		}
+		if !dbStructure.acceptsDMsFrom(id, creatorID) {
+			return Conversation{}, errors.New("not accepting messages")
+		}
+	}
+	sort.Ints(participants)
+
+	if len(participants) == 2 {
+		for _, conversation := range dbStructure.Conversations {
+			if len(conversation.ParticipantIDs) == 2 &&
+				conversation.ParticipantIDs[0] == participants[0] &&
+				conversation.ParticipantIDs[1] == participants[1] {
+				return conversation, nil
+			}
+		}
+	}
+
+	// conversation ids are never reused, so a client still holding the id of
+	// a conversation everyone deleted can't end up in a new one
+	id := dbStructure.LastConversationID + 1
+	for conversationID := range dbStructure.Conversations {
+		if conversationID >= id {
+			id = conversationID + 1
+		}
+	}
+	dbStructure.LastConversationID = id
+	now := time.Now().UTC()
+	conversation := Conversation{
+		ID:             id,
+		ParticipantIDs: participants,
+		CreatedBy:      creatorID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	dbStructure.Conversations[id] = conversation
+	dbStructure.ConversationMembers[id] = map[int]ConversationMember{}
+	for _, userID := range participants {
+		dbStructure.ConversationMembers[id][userID] = ConversationMember{}
+	}
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// member returns the conversation if userID takes part in it.
+func (dbStructure *DBStructure) member(conversationID, userID int) (Conversation, ConversationMember, error) {
+	conversation, ok := dbStructure.Conversations[conversationID]
+	if !ok {
+		return Conversation{}, ConversationMember{}, errors.New("conversation not found")
+	}
+	member, ok := dbStructure.ConversationMembers[conversationID][userID]
+	if !ok {
+		return Conversation{}, ConversationMember{}, errors.New("conversation not found")
+	}
+	return conversation, member, nil
+}
+
+// SendMessage adds a message from senderID to the conversation. In
+// one-to-one conversations the other user has to still accept messages
+// from the sender, so a block or a change of policy takes effect at once,
+// unless they started the conversation themselves; blocks apply either way.
+func (db *DB) SendMessage(conversationID, senderID int, body string) (Message, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Message{}, err
+	}
+	conversation, member, err := dbStructure.member(conversationID, senderID)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(conversation.ParticipantIDs) == 2 {
+		for _, userID := range conversation.ParticipantIDs {
+			if userID == senderID {
+				continue
+			}
+			if dbStructure.isBlocked(userID, senderID) {
+				return Message{}, errors.New("blocked")
+			}
+			if userID != conversation.CreatedBy && !dbStructure.acceptsDMsFrom(userID, senderID) {
+				return Message{}, errors.New("not accepting messages")
+			}
+		}
+	}
+
+	id := dbStructure.LastMessageID + 1
+	dbStructure.LastMessageID = id
+	message := Message{
+		ID:             id,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Body:           body,
+		CreatedAt:      time.Now().UTC(),
+	}
+	dbStructure.Messages[id] = message
+	conversation.LastMessageID = id
+	conversation.UpdatedAt = message.CreatedAt
+	dbStructure.Conversations[conversationID] = conversation
+	member.LastReadID = id
+	dbStructure.ConversationMembers[conversationID][senderID] = member
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+// summarize returns the conversation as userID sees it.
+func (dbStructure *DBStructure) summarize(conversation Conversation, member ConversationMember) ConversationSummary {
+	summary := ConversationSummary{Conversation: conversation}
+	if conversation.LastMessageID > member.ClearedThrough {
+		last := dbStructure.Messages[conversation.LastMessageID]
+		summary.LastMessage = &last
+	}
+	for id, message := range dbStructure.Messages {
+		if message.ConversationID == conversation.ID && id > member.LastReadID && id > member.ClearedThrough {
+			summary.UnreadCount++
+		}
+	}
+	return summary
+}
+
+// ListConversations returns the conversations of userID, most recently
+// active first. Conversations they deleted are left out until a new
+// message arrives.
+func (db *DB) ListConversations(userID int, q PageQuery) (ConversationPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ConversationPage{}, err
+	}
+	items := []PageKey{}
+	for id, members := range dbStructure.ConversationMembers {
+		member, ok := members[userID]
+		if !ok {
+			continue
+		}
+		conversation := dbStructure.Conversations[id]
+		if member.hides(conversation) {
+			continue
+		}
+		items = append(items, PageKey{ID: id, Key: conversation.UpdatedAt.UnixNano()})
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := ConversationPage{
+		Conversations: make([]ConversationSummary, 0, len(window)),
+		Next:          next,
+		Prev:          prev,
+	}
+	for _, item := range window {
+		member := dbStructure.ConversationMembers[item.ID][userID]
+		page.Conversations = append(page.Conversations, dbStructure.summarize(dbStructure.Conversations[item.ID], member))
+	}
+	return page, nil
+}
+
+func (db *DB) GetConversation(conversationID, userID int) (ConversationSummary, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ConversationSummary{}, err
+	}
+	conversation, member, err := dbStructure.member(conversationID, userID)
+	if err != nil {
+		return ConversationSummary{}, err
+	}
+	return dbStructure.summarize(conversation, member), nil
+}
+
+// ListMessages returns the messages of a conversation userID takes part in,
+// leaving out those from before they last deleted it.
+func (db *DB) ListMessages(conversationID, userID int, q PageQuery) (MessagePage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return MessagePage{}, err
+	}
+	_, member, err := dbStructure.member(conversationID, userID)
+	if err != nil {
+		return MessagePage{}, err
+	}
+	items := []PageKey{}
+	for id, message := range dbStructure.Messages {
+		if message.ConversationID == conversationID && id > member.ClearedThrough {
+			items = append(items, PageKey{ID: id, Key: int64(id)})
+		}
+	}
+	window, next, prev := paginate(items, q)
+
+	page := MessagePage{
+		Messages: make([]Message, 0, len(window)),
+		Next:     next,
+		Prev:     prev,
+	}
+	for _, item := range window {
+		page.Messages = append(page.Messages, dbStructure.Messages[item.ID])
+	}
+	return page, nil
+}
+
+// MarkConversationRead marks the messages of a conversation read for userID.
+func (db *DB) MarkConversationRead(conversationID, userID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	conversation, member, err := dbStructure.member(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if member.LastReadID == conversation.LastMessageID {
+		return nil
+	}
+	member.LastReadID = conversation.LastMessageID
+	dbStructure.ConversationMembers[conversationID][userID] = member
+	return db.writeDB(dbStructure)
+}
+
+func (db *DB) CountUnreadMessages(userID int) (UnreadMessageCounts, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UnreadMessageCounts{}, err
+	}
+	counts := UnreadMessageCounts{}
+	for id, members := range dbStructure.ConversationMembers {
+		member, ok := members[userID]
+		if !ok {
+			continue
+		}
+		unread := dbStructure.summarize(dbStructure.Conversations[id], member).UnreadCount
+		counts.Messages += unread
+		if unread > 0 {
+			counts.Conversations++
+		}
+	}
+	return counts, nil
+}
+
+// DeleteConversation deletes a conversation for userID only: its messages
+// so far are hidden from them, while the other participants keep theirs.
+// Once every participant has deleted it, it's removed for good.
+func (db *DB) DeleteConversation(conversationID, userID int) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	conversation, member, err := dbStructure.member(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	member.ClearedThrough = conversation.LastMessageID
+	member.LastReadID = conversation.LastMessageID
+	member.Deleted = true
+	dbStructure.ConversationMembers[conversationID][userID] = member
+
+	// messages every participant has deleted are gone for good, and so is
+	// the conversation once all of them have deleted it
+	deletedByAll := true
+	oldestKept := conversation.LastMessageID
+	for _, other := range dbStructure.ConversationMembers[conversationID] {
+		if !other.hides(conversation) {
+			deletedByAll = false
+		}
+		if other.ClearedThrough < oldestKept {
+			oldestKept = other.ClearedThrough
+		}
+	}
+	for id, message := range dbStructure.Messages {
+		if message.ConversationID == conversationID && id <= oldestKept {
+			delete(dbStructure.Messages, id)
+		}
+	}
+	if deletedByAll {
+		delete(dbStructure.Conversations, conversationID)
+		delete(dbStructure.ConversationMembers, conversationID)
+	}
+	return db.writeDB(dbStructure)
+}
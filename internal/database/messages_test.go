@@ -0,0 +1,34 @@
+package database
+
+import "testing"
+
+func TestConversationIDsAreNotReused(t *testing.T) {
+	db := newTestDB(t, 3)
+	first, err := db.StartConversation(1, []int{2})
+	if err != nil {
+		t.Fatalf("StartConversation: %v", err)
+	}
+	group, err := db.StartConversation(1, []int{2, 3})
+	if err != nil {
+		t.Fatalf("StartConversation: %v", err)
+	}
+	if _, err := db.SendMessage(group.ID, 1, "hi both"); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	for _, userID := range group.ParticipantIDs {
+		if err := db.DeleteConversation(group.ID, userID); err != nil {
+			t.Fatalf("DeleteConversation: %v", err)
+		}
+	}
+
+	conversation, err := db.StartConversation(2, []int{3})
+	if err != nil {
+		t.Fatalf("StartConversation: %v", err)
+	}
+	if conversation.ID == group.ID || conversation.ID == first.ID {
+		t.Errorf("new conversation got id %d, already used by %d and %d", conversation.ID, first.ID, group.ID)
+	}
+	if _, err := db.GetConversation(group.ID, 2); err == nil || err.Error() != "conversation not found" {
+		t.Errorf("GetConversation of the deleted conversation: %v, want conversation not found", err)
+	}
+}
@@ -0,0 +1,501 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ModerationHit records that a rule of a content moderation stage fired.
+type ModerationHit struct {
+	Stage  string `json:"stage"`
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+}
+
+const (
+	ReportTargetChirp = "chirp"
+	ReportTargetUser  = "user"
+)
+
+// ReportCategories are the reasons a user can give for a report.
+var ReportCategories = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"sexual":         true,
+	"self_harm":      true,
+	"misinformation": true,
+	"impersonation":  true,
+	"other":          true,
+}
+
+const (
+	CaseOpen     = "open"
+	CaseAppealed = "appealed"
+	CaseClosed   = "closed"
+)
+
+const (
+	DecisionDismiss        = "dismiss"
+	DecisionRemoveChirp    = "remove_chirp"
+	DecisionDisablePosting = "disable_posting"
+	DecisionUphold         = "uphold"
+	DecisionOverturn       = "overturn"
+)
+
+type Report struct {
+	ID         int       `json:"id"`
+	CaseID     int       `json:"case_id"`
+	ReporterID int       `json:"reporter_id"`
+	Category   string    `json:"category"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Appeal struct {
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerationCase collects the reports and automatic flags about one chirp or
+// account until a moderator decides on it. UserID is the reported account,
+// or the author of the reported chirp. Chirp keeps a copy of a chirp that was
+// deleted while the case was open, or removed by a moderator, so it can still
+// be reviewed and, on appeal, restored.
+type ModerationCase struct {
+	ID         int             `json:"id"`
+	TargetType string          `json:"target_type"`
+	ChirpID    int             `json:"chirp_id,omitempty"`
+	UserID     int             `json:"user_id"`
+	Status     string          `json:"status"`
+	ReportIDs  []int           `json:"report_ids"`
+	Flags      []ModerationHit `json:"flags,omitempty"`
+	Chirp      *Chirp          `json:"chirp,omitempty"`
+	Decision   string          `json:"decision,omitempty"`
+	Appeal     *Appeal         `json:"appeal,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ModerationDecision is an entry of the moderation audit trail.
+type ModerationDecision struct {
+	ID          int       `json:"id"`
+	CaseID      int       `json:"case_id"`
+	ModeratorID int       `json:"moderator_id"`
+	Action      string    `json:"action"`
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// pendingCase returns the case still awaiting a decision about the target,
+// opening a new one if there is none.
+func (dbStructure *DBStructure) pendingCase(targetType string, chirpID, userID int) ModerationCase {
+	for _, c := range dbStructure.ModerationCases {
+		if c.TargetType == targetType && c.ChirpID == chirpID && c.UserID == userID && c.Status != CaseClosed {
+			return c
+		}
+	}
+	// case ids are never reused, so decisions in the audit trail can't end
+	// up pointing at another case
+	id := dbStructure.LastModerationCaseID + 1
+	for caseID := range dbStructure.ModerationCases {
+		if caseID >= id {
+			id = caseID + 1
+		}
+	}
+	dbStructure.LastModerationCaseID = id
+	now := time.Now().UTC()
+	return ModerationCase{
+		ID:         id,
+		TargetType: targetType,
+		ChirpID:    chirpID,
+		UserID:     userID,
+		Status:     CaseOpen,
+		ReportIDs:  []int{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// CreateReport files a report about a chirp, when chirpID is set, or about
+// the account userID, and adds it to the moderation queue.
+func (db *DB) CreateReport(reporterID, chirpID, userID int, category, comment string) (Report, error) {
+	if !ReportCategories[category] {
+		return Report{}, errors.New("unknown report category")
+	}
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	targetType := ReportTargetUser
+	if chirpID != 0 {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !dbStructure.viewFilterFor(reporterID).canSee(chirp) {
+			return Report{}, errors.New("chirp not found")
+		}
+		targetType = ReportTargetChirp
+		userID = chirp.Author
+	} else if _, ok := dbStructure.Users[userID]; !ok {
+		return Report{}, errors.New("user not found")
+	}
+	if userID == reporterID {
+		return Report{}, errors.New("cannot report yourself")
+	}
+
+	c := dbStructure.pendingCase(targetType, chirpID, userID)
+	for _, reportID := range c.ReportIDs {
+		if dbStructure.Reports[reportID].ReporterID == reporterID {
+			return Report{}, errors.New("already reported")
+		}
+	}
+	id := 1
+	for reportID := range dbStructure.Reports {
+		if reportID >= id {
+			id = reportID + 1
+		}
+	}
+	report := Report{
+		ID:         id,
+		CaseID:     c.ID,
+		ReporterID: reporterID,
+		Category:   category,
+		Comment:    comment,
+		CreatedAt:  time.Now().UTC(),
+	}
+	dbStructure.Reports[id] = report
+	c.ReportIDs = append(c.ReportIDs, id)
+	c.UpdatedAt = report.CreatedAt
+	dbStructure.ModerationCases[c.ID] = c
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// FlagChirp puts a chirp in the moderation queue because of the moderation
+// hits that flagged it, replacing any earlier ones. An empty list clears the
+// flags, and drops the case if nobody reported the chirp either.
+func (db *DB) FlagChirp(chirpID int, hits []ModerationHit) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok {
+		return errors.New("chirp not found")
+	}
+	if !dbStructure.flagChirp(chirp, hits) {
+		return nil
+	}
+	return db.writeDB(dbStructure)
+}
+
+// flagChirp replaces the flags of the case about chirp and reports whether
+// anything changed. An open case left with no flags or reports is dropped.
+func (dbStructure *DBStructure) flagChirp(chirp Chirp, hits []ModerationHit) bool {
+	c := dbStructure.pendingCase(ReportTargetChirp, chirp.ID, chirp.Author)
+	if _, exists := dbStructure.ModerationCases[c.ID]; !exists && len(hits) == 0 {
+		return false
+	}
+	c.Flags = hits
+	c.UpdatedAt = time.Now().UTC()
+	// a case that was decided and appealed stays, even if an edit cleared
+	// the flags that opened it
+	if len(c.Flags) == 0 && len(c.ReportIDs) == 0 && c.Status == CaseOpen {
+		delete(dbStructure.ModerationCases, c.ID)
+	} else {
+		dbStructure.ModerationCases[c.ID] = c
+	}
+	return true
+}
+
+// keepReportedChirp stores a copy of a chirp that is being deleted in the
+// cases still waiting for a decision about it.
+func (dbStructure *DBStructure) keepReportedChirp(chirp Chirp) {
+	for id, c := range dbStructure.ModerationCases {
+		if c.ChirpID == chirp.ID && c.Status != CaseClosed && c.Chirp == nil {
+			snapshot := chirp
+			c.Chirp = &snapshot
+			dbStructure.ModerationCases[id] = c
+		}
+	}
+}
+
+type CasePage struct {
+	Cases []ModerationCase
+	Next  *PageKey
+	Prev  *PageKey
+}
+
+type DecisionPage struct {
+	Decisions []ModerationDecision
+	Next      *PageKey
+	Prev      *PageKey
+}
+
+// ListModerationCases returns the cases with the given status, oldest first.
+func (db *DB) ListModerationCases(status string, q PageQuery) (CasePage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return CasePage{}, err
+	}
+	items := []PageKey{}
+	for id, c := range dbStructure.ModerationCases {
+		if c.Status == status {
+			items = append(items, PageKey{ID: id, Key: int64(id)})
+		}
+	}
+	window, next, prev := paginate(items, q)
+
+	page := CasePage{
+		Cases: make([]ModerationCase, 0, len(window)),
+		Next:  next,
+		Prev:  prev,
+	}
+	for _, item := range window {
+		page.Cases = append(page.Cases, dbStructure.ModerationCases[item.ID])
+	}
+	return page, nil
+}
+
+// GetModerationCase returns a case along with its reports and the current
+// version of the reported chirp, if it still exists.
+func (db *DB) GetModerationCase(id int) (ModerationCase, []Report, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ModerationCase{}, nil, err
+	}
+	c, ok := dbStructure.ModerationCases[id]
+	if !ok {
+		return ModerationCase{}, nil, errors.New("case not found")
+	}
+	if chirp, ok := dbStructure.Chirps[c.ChirpID]; ok && c.Chirp == nil && !chirp.Deleted {
+		c.Chirp = &chirp
+	}
+	reports := make([]Report, 0, len(c.ReportIDs))
+	for _, reportID := range c.ReportIDs {
+		reports = append(reports, dbStructure.Reports[reportID])
+	}
+	return c, reports, nil
+}
+
+// DecideModerationCase applies a moderator's decision to a case and records
+// it in the audit trail. Open cases can be dismissed, have their chirp
+// removed or their user's posting disabled. Appealed cases can only have the
+// earlier decision upheld or overturned.
+func (db *DB) DecideModerationCase(id, moderatorID int, action, note string) (ModerationCase, ModerationDecision, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ModerationCase{}, ModerationDecision{}, err
+	}
+	c, ok := dbStructure.ModerationCases[id]
+	if !ok {
+		return ModerationCase{}, ModerationDecision{}, errors.New("case not found")
+	}
+
+	switch {
+	case c.Status == CaseOpen && action == DecisionDismiss:
+	case c.Status == CaseOpen && action == DecisionRemoveChirp:
+		chirp, ok := dbStructure.Chirps[c.ChirpID]
+		if c.TargetType != ReportTargetChirp || !ok || chirp.Deleted {
+			return ModerationCase{}, ModerationDecision{}, errors.New("chirp not found")
+		}
+		snapshot := chirp
+		c.Chirp = &snapshot
+		dbStructure.removeChirp(chirp)
+	case c.Status == CaseOpen && action == DecisionDisablePosting:
+		err = dbStructure.setPostingDisabled(c.UserID, true)
+	case c.Status == CaseAppealed && action == DecisionUphold:
+	case c.Status == CaseAppealed && action == DecisionOverturn:
+		if c.Decision == DecisionRemoveChirp {
+			err = dbStructure.restoreChirp(*c.Chirp)
+		} else if c.Decision == DecisionDisablePosting {
+			err = dbStructure.setPostingDisabled(c.UserID, false)
+		}
+	default:
+		return ModerationCase{}, ModerationDecision{}, errors.New("invalid decision")
+	}
+	if err != nil {
+		return ModerationCase{}, ModerationDecision{}, err
+	}
+
+	decisionID := 1
+	for other := range dbStructure.ModerationDecisions {
+		if other >= decisionID {
+			decisionID = other + 1
+		}
+	}
+	decision := ModerationDecision{
+		ID:          decisionID,
+		CaseID:      c.ID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		Note:        note,
+		CreatedAt:   time.Now().UTC(),
+	}
+	dbStructure.ModerationDecisions[decisionID] = decision
+	if c.Status == CaseOpen {
+		c.Decision = action
+	}
+	c.Status = CaseClosed
+	c.UpdatedAt = decision.CreatedAt
+	dbStructure.ModerationCases[c.ID] = c
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return ModerationCase{}, ModerationDecision{}, err
+	}
+	return c, decision, nil
+}
+
+// AppealModerationCase lets the user a decision was taken against ask for it
+// to be reviewed again. Each case can be appealed once.
+func (db *DB) AppealModerationCase(id, userID int, reason string) (ModerationCase, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return ModerationCase{}, err
+	}
+	c, ok := dbStructure.ModerationCases[id]
+	if !ok || c.UserID != userID || c.Status == CaseOpen {
+		return ModerationCase{}, errors.New("case not found")
+	}
+	if c.Decision != DecisionRemoveChirp && c.Decision != DecisionDisablePosting {
+		return ModerationCase{}, errors.New("nothing to appeal")
+	}
+	if c.Appeal != nil {
+		return ModerationCase{}, errors.New("already appealed")
+	}
+	now := time.Now().UTC()
+	c.Appeal = &Appeal{Reason: reason, CreatedAt: now}
+	c.Status = CaseAppealed
+	c.UpdatedAt = now
+	dbStructure.ModerationCases[id] = c
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return ModerationCase{}, err
+	}
+	return c, nil
+}
+
+// GetUserModerationCases returns the decided cases about userID, so they
+// can see what was done and appeal it.
+func (db *DB) GetUserModerationCases(userID int) ([]ModerationCase, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	cases := []ModerationCase{}
+	for _, c := range dbStructure.ModerationCases {
+		if c.UserID == userID && c.Decision != "" && c.Decision != DecisionDismiss {
+			cases = append(cases, c)
+		}
+	}
+	sort.Slice(cases, func(i, j int) bool {
+		return cases[i].ID < cases[j].ID
+	})
+	return cases, nil
+}
+
+// ListModerationDecisions returns the audit trail, newest first.
+func (db *DB) ListModerationDecisions(q PageQuery) (DecisionPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return DecisionPage{}, err
+	}
+	items := make([]PageKey, 0, len(dbStructure.ModerationDecisions))
+	for id := range dbStructure.ModerationDecisions {
+		items = append(items, PageKey{ID: id, Key: int64(id)})
+	}
+	q.Desc = true
+	window, next, prev := paginate(items, q)
+
+	page := DecisionPage{
+		Decisions: make([]ModerationDecision, 0, len(window)),
+		Next:      next,
+		Prev:      prev,
+	}
+	for _, item := range window {
+		page.Decisions = append(page.Decisions, dbStructure.ModerationDecisions[item.ID])
+	}
+	return page, nil
+}
+
+func (dbStructure *DBStructure) setPostingDisabled(userID int, disabled bool) error {
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return errors.New("user not found")
+	}
+	user.PostingDisabled = disabled
+	dbStructure.Users[userID] = user
+	return nil
+}
+
+// restoreChirp puts back a chirp a moderator removed. Likes and rechirps
+// it had are lost, mentions come back as read, and attachments whose media
+// is gone or in use elsewhere are dropped.
+func (dbStructure *DBStructure) restoreChirp(chirp Chirp) error {
+	tombstone, tombstoned := dbStructure.Chirps[chirp.ID]
+	if tombstoned && !tombstone.Deleted {
+		return errors.New("chirp id taken")
+	}
+	chirp.ReplyCount = 0
+	if tombstoned {
+		chirp.ReplyCount = tombstone.ReplyCount
+	}
+	chirp.LikeCount = 0
+	chirp.RechirpCount = 0
+	if chirp.Poll != nil {
+		// the votes went with the chirp, so the tallies start over; copied so
+		// the case's snapshot keeps its own
+		poll := *chirp.Poll
+		poll.Options = make([]PollOption, len(chirp.Poll.Options))
+		for i, option := range chirp.Poll.Options {
+			poll.Options[i] = PollOption{Text: option.Text}
+		}
+		poll.VoteCount = 0
+		chirp.Poll = &poll
+	}
+	chirp.Deleted = false
+	parent, hasParent := dbStructure.Chirps[chirp.InReplyToID]
+	if chirp.InReplyToID != 0 && !hasParent {
+		chirp.InReplyToID = 0
+	}
+	dbStructure.reattachMedia(&chirp)
+
+	dbStructure.Chirps[chirp.ID] = chirp
+	dbStructure.SearchIndex.add(chirp)
+	dbStructure.indexHashtags(chirp)
+	dbStructure.recordMentions(chirp, true)
+	if chirp.InReplyToID != 0 {
+		if tombstoned {
+			// a tombstone stays linked to its parent but isn't counted
+			parent.ReplyCount++
+			dbStructure.Chirps[parent.ID] = parent
+		} else {
+			dbStructure.addReply(chirp)
+		}
+	}
+	if quoted, ok := dbStructure.Chirps[chirp.QuoteOfID]; ok && chirp.QuoteOfID != 0 {
+		quoted.QuoteCount++
+		dbStructure.Chirps[quoted.ID] = quoted
+	}
+	dbStructure.fanOutChirp(chirp)
+	return nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import "container/heap"
+
+// PageKey is a position in an ordered collection: the sort key of an item
+// plus its ID to break ties. When ordering by ID the key is the ID itself.
+type PageKey struct {
+	ID  int
+	Key int64
+}
+
+// PageQuery selects a window of an ordered collection. After and Before are
+// exclusive bounds in the sort order, so with Desc set "after" means a lower
+// key. At most one of them should be set.
+type PageQuery struct {
+	After  *PageKey
+	Before *PageKey
+	Limit  int
+	Desc   bool
+}
+
+// paginate returns the requested window of items in the query's order. It is
+// a bounded range scan: items are visited once and only the Limit nearest the
+// cursor are kept and sorted, so a page costs O(n log Limit) rather than a
+// sort of the whole collection. next and prev are the positions to continue
+// from, or nil when there is nothing more in that direction.
+func paginate(items []PageKey, q PageQuery) (window []PageKey, next, prev *PageKey) {
+	// comesAfter reports whether a sorts after b in the query's order
+	comesAfter := func(a, b PageKey) bool {
+		if a.Key != b.Key {
+			return (a.Key > b.Key) != q.Desc
+		}
+		return (a.ID > b.ID) != q.Desc
+	}
+	// a page before the cursor ends right at it, any other page starts there
+	backward := q.Before != nil
+	nearer := func(a, b PageKey) bool {
+		if backward {
+			return comesAfter(a, b)
+		}
+		return comesAfter(b, a)
+	}
+
+	kept := &pageHeap{farther: func(a, b PageKey) bool { return nearer(b, a) }}
+	inRange := 0
+	for _, item := range items {
+		if backward && !comesAfter(*q.Before, item) {
+			continue
+		}
+		if !backward && q.After != nil && !comesAfter(item, *q.After) {
+			continue
+		}
+		inRange++
+		if q.Limit <= 0 {
+			continue
+		}
+		if kept.Len() < q.Limit {
+			heap.Push(kept, item)
+		} else if nearer(item, kept.items[0]) {
+			kept.items[0] = item
+			heap.Fix(kept, 0)
+		}
+	}
+
+	// the heap pops the farthest item first
+	window = make([]PageKey, kept.Len())
+	for i := range window {
+		item := heap.Pop(kept).(PageKey)
+		if backward {
+			window[i] = item
+		} else {
+			window[len(window)-1-i] = item
+		}
+	}
+
+	beyond := inRange > len(window)
+	behind := len(items) > inRange
+	if backward {
+		beyond, behind = behind, beyond
+	}
+	if len(window) > 0 && beyond {
+		next = &window[len(window)-1]
+	}
+	if len(window) > 0 && behind {
+		prev = &window[0]
+	}
+	return window, next, prev
+}
+
+// pageHeap keeps the farthest of the items kept for a page at the root, so
+// it can be swapped out when a nearer one turns up.
+type pageHeap struct {
+	items   []PageKey
+	farther func(a, b PageKey) bool
+}
+
+func (h *pageHeap) Len() int           { return len(h.items) }
+func (h *pageHeap) Less(i, j int) bool { return h.farther(h.items[i], h.items[j]) }
+func (h *pageHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *pageHeap) Push(x any)         { h.items = append(h.items, x.(PageKey)) }
+func (h *pageHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+type UserPage struct {
+	Users []User
+	Next  *PageKey
+	Prev  *PageKey
+}
+
+func (db *DB) ListUsers(q PageQuery) (UserPage, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	items := make([]PageKey, 0, len(dbStructure.Users))
+	for id := range dbStructure.Users {
+		items = append(items, PageKey{ID: id, Key: int64(id)})
+	}
+	window, next, prev := paginate(items, q)
+
+	page := UserPage{
+		Users: make([]User, 0, len(window)),
+		Next:  next,
+		Prev:  prev,
+	}
+	for _, item := range window {
+		page.Users = append(page.Users, dbStructure.Users[item.ID].public())
+	}
+	return page, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sortedPage is the straightforward version of paginate: sort everything,
+// then cut the window out.
+func sortedPage(items []PageKey, q PageQuery) (window []PageKey, next, prev *PageKey) {
+	comesAfter := func(a, b PageKey) bool {
+		if a.Key != b.Key {
+			return (a.Key > b.Key) != q.Desc
+		}
+		return (a.ID > b.ID) != q.Desc
+	}
+	items = append([]PageKey(nil), items...)
+	sort.Slice(items, func(i, j int) bool { return comesAfter(items[j], items[i]) })
+
+	start, end := 0, len(items)
+	if q.Before != nil {
+		end = sort.Search(len(items), func(i int) bool { return !comesAfter(*q.Before, items[i]) })
+		start = max(end-q.Limit, 0)
+	} else {
+		if q.After != nil {
+			start = sort.Search(len(items), func(i int) bool { return comesAfter(items[i], *q.After) })
+		}
+		end = min(start+q.Limit, end)
+	}
+	window = items[start:end]
+	if len(window) > 0 && end < len(items) {
+		next = &window[len(window)-1]
+	}
+	if len(window) > 0 && start > 0 {
+		prev = &window[0]
+	}
+	return window, next, prev
+}
+
+func TestPaginateMatchesSortedPage(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 2000; run++ {
+		items := make([]PageKey, rng.Intn(30))
+		for i := range items {
+			// few distinct keys, so ties on the key are common
+			items[i] = PageKey{ID: i + 1, Key: int64(rng.Intn(8))}
+		}
+		rng.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+
+		q := PageQuery{Limit: rng.Intn(6), Desc: rng.Intn(2) == 0}
+		cursor := &PageKey{ID: rng.Intn(32), Key: int64(rng.Intn(8))}
+		switch rng.Intn(3) {
+		case 1:
+			q.After = cursor
+		case 2:
+			q.Before = cursor
+		}
+
+		wantWindow, wantNext, wantPrev := sortedPage(items, q)
+		window, next, prev := paginate(append([]PageKey(nil), items...), q)
+		if len(window) != len(wantWindow) || (len(window) > 0 && !reflect.DeepEqual(window, wantWindow)) {
+			t.Fatalf("query %+v over %v: window %v, want %v", q, items, window, wantWindow)
+		}
+		if !reflect.DeepEqual(next, wantNext) || !reflect.DeepEqual(prev, wantPrev) {
+			t.Fatalf("query %+v over %v: next, prev = %v, %v, want %v, %v", q, items, next, prev, wantNext, wantPrev)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 4
+	// MaxPollOptionLength is the longest option text, in characters.
+	MaxPollOptionLength = 25
+	MaxPollDuration     = 7 * 24 * time.Hour
+)
+
+// NewPoll is the poll a new chirp asks for.
+type NewPoll struct {
+	Options  []string  `json:"options"`
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Poll is a chirp's poll and its running tallies. Who voted for what is kept
+// in PollVotes. ResultsSent is set once the author has been sent the final
+// results after it closed.
+type Poll struct {
+	Options     []PollOption `json:"options"`
+	ClosesAt    time.Time    `json:"closes_at"`
+	VoteCount   int          `json:"vote_count"`
+	ResultsSent bool         `json:"results_sent,omitempty"`
+}
+
+// Closed reports whether voting has ended by now.
+func (p Poll) Closed(now time.Time) bool {
+	return !now.Before(p.ClosesAt)
+}
+
+// checkPoll validates a new poll against the time it would be published.
+func checkPoll(poll *NewPoll, now time.Time) error {
+	if poll == nil {
+		return nil
+	}
+	if len(poll.Options) < MinPollOptions || len(poll.Options) > MaxPollOptions {
+		return errors.New("invalid poll options")
+	}
+	seen := map[string]bool{}
+	for _, option := range poll.Options {
+		key := strings.ToLower(strings.TrimSpace(option))
+		if key == "" || seen[key] {
+			return errors.New("invalid poll options")
+		}
+		if utf8.RuneCountInString(option) > MaxPollOptionLength {
+			return errors.New("poll option too long")
+		}
+		seen[key] = true
+	}
+	if !poll.ClosesAt.After(now) || poll.ClosesAt.Sub(now) > MaxPollDuration {
+		return errors.New("invalid poll closing time")
+	}
+	return nil
+}
+
+func newPoll(poll *NewPoll) *Poll {
+	options := make([]PollOption, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		options = append(options, PollOption{Text: strings.TrimSpace(option)})
+	}
+	return &Poll{Options: options, ClosesAt: poll.ClosesAt.UTC()}
+}
+
+// VotePoll records userID's vote for an option of a chirp's poll, replacing
+// any vote they cast before. Votes are counted while the database is locked,
+// so concurrent votes never lose each other's tallies.
+func (db *DB) VotePoll(chirpID, userID, option int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted || !dbStructure.canView(userID, chirp) {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if chirp.Poll == nil {
+		return Chirp{}, errors.New("no poll")
+	}
+	if chirp.Poll.Closed(time.Now()) {
+		return Chirp{}, errors.New("poll closed")
+	}
+	if option < 0 || option >= len(chirp.Poll.Options) {
+		return Chirp{}, errors.New("invalid option")
+	}
+
+	if dbStructure.PollVotes[chirpID] == nil {
+		dbStructure.PollVotes[chirpID] = map[int]int{}
+	}
+	previous, voted := dbStructure.PollVotes[chirpID][userID]
+	if voted && previous == option {
+		return chirp, nil
+	}
+	if voted {
+		chirp.Poll.Options[previous].Votes--
+	} else {
+		chirp.Poll.VoteCount++
+	}
+	chirp.Poll.Options[option].Votes++
+	dbStructure.PollVotes[chirpID][userID] = option
+	dbStructure.Chirps[chirpID] = chirp
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// CloseDuePolls returns the chirps whose polls have closed by now and whose
+// authors haven't been sent the results yet, oldest first. Each poll is only
+// returned once, since it's marked in the same write.
+func (db *DB) CloseDuePolls(now time.Time) ([]Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	closed := []Chirp{}
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.Deleted || chirp.Poll == nil || chirp.Poll.ResultsSent || !chirp.Poll.Closed(now) {
+			continue
+		}
+		chirp.Poll.ResultsSent = true
+		dbStructure.Chirps[id] = chirp
+		closed = append(closed, chirp)
+	}
+	if len(closed) == 0 {
+		return nil, nil
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		if !closed[i].Poll.ClosesAt.Equal(closed[j].Poll.ClosesAt) {
+			return closed[i].Poll.ClosesAt.Before(closed[j].Poll.ClosesAt)
+		}
+		return closed[i].ID < closed[j].ID
+	})
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestPoll(t *testing.T, db *DB) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp("pick one", 1, ChirpOptions{Poll: &NewPoll{
+		Options:  []string{"red", "green", "blue"},
+		ClosesAt: time.Now().Add(time.Hour),
+	}})
+	if err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	return chirp
+}
+
+func TestConcurrentPollVotes(t *testing.T) {
+	const users = 30
+	tests := []struct {
+		name string
+		// every user votes once per round, all users at once, for the option
+		// vote returns; only the last round counts
+		rounds []func(userID int) int
+	}{
+		{
+			name:   "votes",
+			rounds: []func(int) int{func(u int) int { return u % 3 }},
+		},
+		{
+			name:   "changed votes",
+			rounds: []func(int) int{func(u int) int { return u % 3 }, func(u int) int { return (u + 1) % 3 }},
+		},
+		{
+			name:   "repeated votes",
+			rounds: []func(int) int{func(int) int { return 0 }, func(int) int { return 0 }},
+		},
+		{
+			name:   "everyone changes to one option",
+			rounds: []func(int) int{func(u int) int { return u % 3 }, func(int) int { return 2 }},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, users)
+			chirp := newTestPoll(t, db)
+
+			for _, vote := range tt.rounds {
+				var wg sync.WaitGroup
+				for userID := 1; userID <= users; userID++ {
+					wg.Add(1)
+					go func(userID int) {
+						defer wg.Done()
+						if _, err := db.VotePoll(chirp.ID, userID, vote(userID)); err != nil {
+							t.Errorf("user %d: %v", userID, err)
+						}
+					}(userID)
+				}
+				wg.Wait()
+			}
+
+			want := make([]int, 3)
+			last := tt.rounds[len(tt.rounds)-1]
+			for userID := 1; userID <= users; userID++ {
+				want[last(userID)]++
+			}
+			got, err := db.GetChirp(strconv.Itoa(chirp.ID))
+			if err != nil {
+				t.Fatalf("GetChirp: %v", err)
+			}
+			if got.Poll.VoteCount != users {
+				t.Errorf("VoteCount = %d, want %d", got.Poll.VoteCount, users)
+			}
+			for i, option := range got.Poll.Options {
+				if option.Votes != want[i] {
+					t.Errorf("option %d has %d votes, want %d", i, option.Votes, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOverturnedRemovalResetsPoll(t *testing.T) {
+	db := newTestDB(t, 3)
+	chirp := newTestPoll(t, db)
+	for userID := 1; userID <= 3; userID++ {
+		if _, err := db.VotePoll(chirp.ID, userID, 0); err != nil {
+			t.Fatalf("VotePoll: %v", err)
+		}
+	}
+	if err := db.FlagChirp(chirp.ID, []ModerationHit{{Stage: "wordlist", Rule: "red", Action: "flag"}}); err != nil {
+		t.Fatalf("FlagChirp: %v", err)
+	}
+	page, err := db.ListModerationCases(CaseOpen, PageQuery{Limit: 10})
+	if err != nil || len(page.Cases) != 1 {
+		t.Fatalf("ListModerationCases: %v, %v", page.Cases, err)
+	}
+	caseID := page.Cases[0].ID
+	if _, _, err := db.DecideModerationCase(caseID, 2, DecisionRemoveChirp, ""); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := db.AppealModerationCase(caseID, 1, "it's a poll"); err != nil {
+		t.Fatalf("AppealModerationCase: %v", err)
+	}
+	c, _, err := db.DecideModerationCase(caseID, 2, DecisionOverturn, "")
+	if err != nil {
+		t.Fatalf("overturn: %v", err)
+	}
+	if c.Chirp.Poll.VoteCount != 3 {
+		t.Errorf("case snapshot lost its tallies: %+v", c.Chirp.Poll)
+	}
+
+	got, err := db.GetChirp(strconv.Itoa(chirp.ID))
+	if err != nil {
+		t.Fatalf("GetChirp: %v", err)
+	}
+	if got.Poll.VoteCount != 0 || got.Poll.Options[0].Votes != 0 {
+		t.Errorf("restored poll kept its tallies: %+v", got.Poll)
+	}
+	// the votes were deleted with the chirp, so everyone can vote again and
+	// the counts stay consistent
+	for userID := 1; userID <= 3; userID++ {
+		if _, err := db.VotePoll(chirp.ID, userID, 1); err != nil {
+			t.Fatalf("VotePoll: %v", err)
+		}
+	}
+	got, _ = db.GetChirp(strconv.Itoa(chirp.ID))
+	if got.Poll.VoteCount != 3 || got.Poll.Options[0].Votes != 0 || got.Poll.Options[1].Votes != 3 {
+		t.Errorf("poll after voting again: %+v", got.Poll)
+	}
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxWebsiteLength     = 100
+	// MaxPinnedChirps is how many chirps a profile can pin.
+	MaxPinnedChirps = 3
+)
+
+// handlePattern matches the handles mentions can refer to.
+var handlePattern = regexp.MustCompile(`^\w{1,15}$`)
+
+// reservedHandles can't be taken by anyone, since they would be confused
+// with the service itself or with parts of its URLs.
+var reservedHandles = map[string]bool{
+	"admin": true, "administrator": true, "api": true, "app": true,
+	"chirpy": true, "help": true, "login": true, "logout": true, "me": true,
+	"moderator": true, "null": true, "root": true, "security": true,
+	"settings": true, "signup": true, "support": true, "system": true,
+	"undefined": true,
+}
+
+// checkHandle validates a handle chosen by a user. Handles that are only
+// digits aren't allowed, so a handle can't be mistaken for a user ID.
+func checkHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("invalid handle")
+	}
+	if _, err := strconv.Atoi(handle); err == nil {
+		return errors.New("invalid handle")
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return errors.New("reserved handle")
+	}
+	return nil
+}
+
+// ProfileUpdate holds the public profile of a user. An empty Handle keeps
+// the current one and an AvatarMediaID of 0 removes the avatar.
+type ProfileUpdate struct {
+	Handle        string
+	DisplayName   string
+	Bio           string
+	Website       string
+	Location      string
+	AvatarMediaID int
+}
+
+func checkProfile(update ProfileUpdate) error {
+	if utf8.RuneCountInString(update.DisplayName) > MaxDisplayNameLength {
+		return errors.New("display name too long")
+	}
+	if utf8.RuneCountInString(update.Bio) > MaxBioLength {
+		return errors.New("bio too long")
+	}
+	if utf8.RuneCountInString(update.Location) > MaxLocationLength {
+		return errors.New("location too long")
+	}
+	if update.Website != "" {
+		u, err := url.Parse(update.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(update.Website) > MaxWebsiteLength {
+			return errors.New("invalid website")
+		}
+	}
+	return nil
+}
+
+// UpdateProfile replaces the public profile of a user. Handles are unique
+// regardless of case; a user can change the case of their own.
+func (db *DB) UpdateProfile(userID int, update ProfileUpdate) (User, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return User{}, errors.New("user not found")
+	}
+	update.Handle = strings.TrimPrefix(strings.TrimSpace(update.Handle), "@")
+	if update.Handle == "" {
+		update.Handle = user.Handle
+	}
+	if update.Handle != user.Handle {
+		err = checkHandle(update.Handle)
+		if err != nil {
+			return User{}, err
+		}
+		if other := dbStructure.userByHandle(update.Handle); other != nil && other.ID != userID {
+			return User{}, errors.New("handle taken")
+		}
+	}
+	update.DisplayName = strings.TrimSpace(update.DisplayName)
+	update.Bio = strings.TrimSpace(update.Bio)
+	update.Website = strings.TrimSpace(update.Website)
+	update.Location = strings.TrimSpace(update.Location)
+	err = checkProfile(update)
+	if err != nil {
+		return User{}, err
+	}
+	if update.AvatarMediaID != 0 {
+		media, ok := dbStructure.Media[update.AvatarMediaID]
+		if !ok || media.OwnerID != userID {
+			return User{}, errors.New("media not found")
+		}
+	}
+
+	user.Handle = update.Handle
+	user.DisplayName = update.DisplayName
+	user.Bio = update.Bio
+	user.Website = update.Website
+	user.Location = update.Location
+	user.AvatarMediaID = update.AvatarMediaID
+	dbStructure.Users[userID] = user
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return User{}, err
+	}
+	return user.public(), nil
+}
+
+// public returns the parts of a user anyone may see, leaving out their email,
+// password and settings.
+func (user User) public() User {
+	return User{
+		ID:             user.ID,
+		IsChirpyRed:    user.IsChirpyRed,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		Website:        user.Website,
+		Location:       user.Location,
+		AvatarMediaID:  user.AvatarMediaID,
+		PinnedChirpIDs: user.PinnedChirpIDs,
+	}
+}
+
+// Profile is a user's public profile as seen by a particular viewer. Pinned
+// chirps the viewer can't read are left out.
+type Profile struct {
+	User         User
+	Counts       FollowCounts
+	ChirpCount   int
+	PinnedChirps []Chirp
+}
+
+// GetProfile looks a user up by ID or by handle, with or without the @, and
+// returns their profile.
+func (db *DB) GetProfile(ref string, viewerID int) (Profile, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Profile{}, err
+	}
+	var user User
+	if id, err := strconv.Atoi(ref); err == nil {
+		found, ok := dbStructure.Users[id]
+		if !ok {
+			return Profile{}, errors.New("user not found")
+		}
+		user = found
+	} else {
+		found := dbStructure.userByHandle(ref)
+		if found == nil {
+			return Profile{}, errors.New("user not found")
+		}
+		user = *found
+	}
+
+	return dbStructure.profiles([]User{user}, viewerID)[0], nil
+}
+
+// GetProfiles returns the profiles of users, in the same order, as viewerID
+// sees them. Users that no longer exist are left out.
+func (db *DB) GetProfiles(users []User, viewerID int) ([]Profile, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	existing := make([]User, 0, len(users))
+	for _, user := range users {
+		if found, ok := dbStructure.Users[user.ID]; ok {
+			existing = append(existing, found)
+		}
+	}
+	return dbStructure.profiles(existing, viewerID), nil
+}
+
+// profiles builds the profiles of users, counting their chirps in a single
+// pass over all chirps.
+func (dbStructure *DBStructure) profiles(users []User, viewerID int) []Profile {
+	chirpCounts := make(map[int]int, len(users))
+	for _, user := range users {
+		chirpCounts[user.ID] = 0
+	}
+	for _, chirp := range dbStructure.Chirps {
+		if _, ok := chirpCounts[chirp.Author]; ok && !chirp.Deleted {
+			chirpCounts[chirp.Author]++
+		}
+	}
+
+	profiles := make([]Profile, 0, len(users))
+	for _, user := range users {
+		profile := Profile{
+			User: user.public(),
+			Counts: FollowCounts{
+				Followers: len(dbStructure.Followers[user.ID]),
+				Following: len(dbStructure.Following[user.ID]),
+			},
+			ChirpCount:   chirpCounts[user.ID],
+			PinnedChirps: []Chirp{},
+		}
+		for _, chirpID := range user.PinnedChirpIDs {
+			chirp, ok := dbStructure.Chirps[chirpID]
+			if ok && !chirp.Deleted && dbStructure.canView(viewerID, chirp) {
+				profile.PinnedChirps = append(profile.PinnedChirps, chirp)
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// PinChirp pins one of userID's chirps to the top of their profile, before
+// the ones already pinned. Pinning a chirp again moves it to the top.
+func (db *DB) PinChirp(chirpID, userID int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if chirp.Author != userID {
+		return Chirp{}, errors.New("unauthorized")
+	}
+	user := dbStructure.Users[userID]
+	pinned := []int{chirpID}
+	for _, id := range user.PinnedChirpIDs {
+		if id != chirpID {
+			pinned = append(pinned, id)
+		}
+	}
+	if len(pinned) > MaxPinnedChirps {
+		return Chirp{}, errors.New("too many pinned chirps")
+	}
+	user.PinnedChirpIDs = pinned
+	dbStructure.Users[userID] = user
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// UnpinChirp removes a chirp from userID's pinned chirps. Unpinning a chirp
+// that isn't pinned is a no-op.
+func (db *DB) UnpinChirp(chirpID, userID int) (Chirp, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, errors.New("chirp not found")
+	}
+	if chirp.Author != userID {
+		return Chirp{}, errors.New("unauthorized")
+	}
+	dbStructure.unpinChirp(chirp)
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// unpinChirp drops a chirp from its author's pinned chirps.
+func (dbStructure *DBStructure) unpinChirp(chirp Chirp) {
+	user, ok := dbStructure.Users[chirp.Author]
+	if !ok {
+		return
+	}
+	pinned := []int{}
+	for _, id := range user.PinnedChirpIDs {
+		if id != chirp.ID {
+			pinned = append(pinned, id)
+		}
+	}
+	if len(pinned) == len(user.PinnedChirpIDs) {
+		return
+	}
+	user.PinnedChirpIDs = pinned
+	dbStructure.Users[chirp.Author] = user
+}
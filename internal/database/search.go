@@ -0,0 +1,326 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchIndexVersion is bumped whenever tokenization changes, which makes
+// NewDB rebuild the index from the stored chirps.
+const searchIndexVersion = 1
+
+const (
+	RankRelevance = "relevance"
+	RankRecency   = "recency"
+)
+
+// bm25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchIndex is an inverted index over chirp bodies. Postings maps each term
+// to the chirps containing it and the term's positions in each of them.
+type SearchIndex struct {
+	Version    int                      `json:"version"`
+	Postings   map[string]map[int][]int `json:"postings"`
+	DocLengths map[int]int              `json:"docLengths"`
+}
+
+func newSearchIndex() SearchIndex {
+	return SearchIndex{
+		Version:    searchIndexVersion,
+		Postings:   map[string]map[int][]int{},
+		DocLengths: map[int]int{},
+	}
+}
+
+// isWordRune reports whether r can be part of a word. Marks are included so
+// combining accents stay attached to their letter.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+// isIdeograph reports whether r is written without spaces between words, in
+// which case every character is a word of its own.
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// tokenize splits text into lowercase terms on Unicode word boundaries.
+func tokenize(text string) []string {
+	terms := []string{}
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			terms = append(terms, strings.ToLower(current.String()))
+			current.Reset()
+		}
+	}
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case isIdeograph(r):
+			flush()
+			terms = append(terms, string(r))
+		case isWordRune(r):
+			current.WriteRune(r)
+		case (r == '\'' || r == '’') && current.Len() > 0 && i+1 < len(runes) && isWordRune(runes[i+1]):
+			// keep contractions like "don't" together
+			current.WriteRune('\'')
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+func (idx *SearchIndex) add(chirp Chirp) {
+	terms := tokenize(chirp.Body)
+	for pos, term := range terms {
+		docs, ok := idx.Postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			idx.Postings[term] = docs
+		}
+		docs[chirp.ID] = append(docs[chirp.ID], pos)
+	}
+	idx.DocLengths[chirp.ID] = len(terms)
+}
+
+func (idx *SearchIndex) remove(chirp Chirp) {
+	for _, term := range tokenize(chirp.Body) {
+		docs, ok := idx.Postings[term]
+		if !ok {
+			continue
+		}
+		delete(docs, chirp.ID)
+		if len(docs) == 0 {
+			delete(idx.Postings, term)
+		}
+	}
+	delete(idx.DocLengths, chirp.ID)
+}
+
+// RebuildSearchIndex discards the search index and indexes every stored
+// chirp again.
+func (db *DB) RebuildSearchIndex() error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	dbStructure.SearchIndex = newSearchIndex()
+	for _, chirp := range dbStructure.Chirps {
+		if !chirp.Deleted {
+			dbStructure.SearchIndex.add(chirp)
+		}
+	}
+	return db.writeDB(dbStructure)
+}
+
+func (db *DB) ensureSearchIndex() error {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if dbStructure.SearchIndex.Version == searchIndexVersion {
+		return nil
+	}
+	return db.RebuildSearchIndex()
+}
+
+// searchClause is one part of a search query: a single term, a prefix, or a
+// phrase of consecutive terms.
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseSearchQuery understands bare words, "quoted phrases" and prefixes
+// ending in *.
+func parseSearchQuery(q string) []searchClause {
+	clauses := []searchClause{}
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			// inside quotes
+			if terms := tokenize(part); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			for _, term := range tokenize(word) {
+				clauses = append(clauses, searchClause{terms: []string{term}})
+			}
+			if prefix && len(clauses) > 0 {
+				clauses[len(clauses)-1].prefix = true
+			}
+		}
+	}
+	return clauses
+}
+
+type SearchQuery struct {
+	Text      string
+	AuthorIDs []int
+	Since     time.Time
+	Until     time.Time
+	Rank      string
+	Page      PageQuery
+	ViewerID  int
+}
+
+type SearchResult struct {
+	Chirp
+	Score float64 `json:"score"`
+}
+
+type SearchPage struct {
+	Results []SearchResult
+	Next    *PageKey
+	Prev    *PageKey
+}
+
+// match returns the BM25 contribution of a clause for every chirp it
+// matches.
+func (idx *SearchIndex) match(clause searchClause, avgLength float64) map[int]float64 {
+	n := float64(len(idx.DocLengths))
+	bm25 := func(term string, docs map[int][]int, scores map[int]float64, only map[int]bool) {
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, positions := range docs {
+			if only != nil && !only[id] {
+				continue
+			}
+			tf := float64(len(positions))
+			norm := 1 - bm25B + bm25B*float64(idx.DocLengths[id])/avgLength
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	scores := map[int]float64{}
+	if clause.prefix {
+		for term, docs := range idx.Postings {
+			if strings.HasPrefix(term, clause.terms[0]) {
+				bm25(term, docs, scores, nil)
+			}
+		}
+		return scores
+	}
+
+	if len(clause.terms) == 1 {
+		bm25(clause.terms[0], idx.Postings[clause.terms[0]], scores, nil)
+		return scores
+	}
+
+	// phrase: keep chirps where the terms appear at consecutive positions
+	first := idx.Postings[clause.terms[0]]
+	matched := map[int]bool{}
+	for id, positions := range first {
+		for _, start := range positions {
+			ok := true
+			for offset, term := range clause.terms[1:] {
+				if !containsInt(idx.Postings[term][id], start+offset+1) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				matched[id] = true
+				break
+			}
+		}
+	}
+	for _, term := range clause.terms {
+		bm25(term, idx.Postings[term], scores, matched)
+	}
+	return scores
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchChirps finds the chirps matching every clause of the query text and
+// ranks them by BM25 relevance or by recency.
+func (db *DB) SearchChirps(q SearchQuery) (SearchPage, error) {
+	clauses := parseSearchQuery(q.Text)
+	if len(clauses) == 0 {
+		return SearchPage{}, errors.New("empty search query")
+	}
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return SearchPage{}, err
+	}
+	idx := dbStructure.SearchIndex
+
+	avgLength := 1.0
+	if len(idx.DocLengths) > 0 {
+		total := 0
+		for _, length := range idx.DocLengths {
+			total += length
+		}
+		avgLength = math.Max(float64(total)/float64(len(idx.DocLengths)), 1)
+	}
+
+	var scores map[int]float64
+	for _, clause := range clauses {
+		clauseScores := idx.match(clause, avgLength)
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for id := range scores {
+			if s, ok := clauseScores[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	filter := ChirpQuery{AuthorIDs: q.AuthorIDs, Since: q.Since, Until: q.Until}
+	view := dbStructure.viewFilterFor(q.ViewerID)
+	items := []PageKey{}
+	for id, score := range scores {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || !filter.matches(chirp) || view.hides(chirp) || !view.listed(chirp) {
+			continue
+		}
+		key := PageKey{ID: id, Key: int64(math.Round(score * 1e6))}
+		if q.Rank == RankRecency {
+			key.Key = chirp.CreatedAt.UnixNano()
+		}
+		items = append(items, key)
+	}
+	q.Page.Desc = true
+	window, next, prev := paginate(items, q.Page)
+
+	page := SearchPage{
+		Results: make([]SearchResult, 0, len(window)),
+		Next:    next,
+		Prev:    prev,
+	}
+	for _, item := range window {
+		page.Results = append(page.Results, SearchResult{
+			Chirp: dbStructure.Chirps[item.ID],
+			Score: math.Round(scores[item.ID]*1e4) / 1e4,
+		})
+	}
+	return page, nil
+}
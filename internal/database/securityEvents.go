@@ -0,0 +1,134 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// maxSecurityEventsPerUser bounds how much login history is kept per user.
+const maxSecurityEventsPerUser = 200
+
+type SecurityEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	Method    string    `json:"method,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type KnownDevice struct {
+	Fingerprint string    `json:"fingerprint"`
+	IPRange     string    `json:"ip_range"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+func (db *DB) RecordSecurityEvent(event SecurityEvent) (SecurityEvent, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+
+	id := 1
+	userEvents := []int{}
+	for eventID, e := range dbStructure.SecurityEvents {
+		if eventID >= id {
+			id = eventID + 1
+		}
+		if e.UserID == event.UserID {
+			userEvents = append(userEvents, eventID)
+		}
+	}
+	// drop the oldest events once the user is over the limit
+	if len(userEvents) >= maxSecurityEventsPerUser {
+		sort.Ints(userEvents)
+		for _, eventID := range userEvents[:len(userEvents)-maxSecurityEventsPerUser+1] {
+			delete(dbStructure.SecurityEvents, eventID)
+		}
+	}
+
+	event.ID = id
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	dbStructure.SecurityEvents[id] = event
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+	return event, nil
+}
+
+// GetSecurityEvents returns the user's security events, newest first.
+func (db *DB) GetSecurityEvents(userID int) ([]SecurityEvent, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	events := []SecurityEvent{}
+	for _, event := range dbStructure.SecurityEvents {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	return events, nil
+}
+
+// RememberDevice records that userID logged in from the given device
+// fingerprint and IP range. It reports whether either was new for the user;
+// the very first device a user logs in from is never reported as new.
+func (db *DB) RememberDevice(userID int, fingerprint, ipRange string) (bool, error) {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+
+	devices := dbStructure.KnownDevices[userID]
+	now := time.Now().UTC()
+	seenFingerprint := false
+	seenIPRange := false
+	matched := -1
+	for i, device := range devices {
+		if device.Fingerprint == fingerprint {
+			seenFingerprint = true
+		}
+		if device.IPRange == ipRange {
+			seenIPRange = true
+		}
+		if device.Fingerprint == fingerprint && device.IPRange == ipRange {
+			matched = i
+		}
+	}
+	if matched >= 0 {
+		devices[matched].LastSeen = now
+	} else {
+		devices = append(devices, KnownDevice{
+			Fingerprint: fingerprint,
+			IPRange:     ipRange,
+			FirstSeen:   now,
+			LastSeen:    now,
+		})
+	}
+	isNew := len(dbStructure.KnownDevices[userID]) > 0 && (!seenFingerprint || !seenIPRange)
+	dbStructure.KnownDevices[userID] = devices
+
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return false, err
+	}
+	return isNew, nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import "errors"
+
+func (dbStructure *DBStructure) addReply(reply Chirp) {
+	dbStructure.Replies[reply.InReplyToID] = append(dbStructure.Replies[reply.InReplyToID], reply.ID)
+	parent := dbStructure.Chirps[reply.InReplyToID]
+	parent.ReplyCount++
+	dbStructure.Chirps[parent.ID] = parent
+}
+
+// removeReply unlinks a hard-deleted reply from its parent. A tombstoned
+// parent that's left without replies has nothing to hold together anymore,
+// so it's deleted as well, walking up the thread.
+func (dbStructure *DBStructure) removeReply(reply Chirp) {
+	parentID := reply.InReplyToID
+	siblings := dbStructure.Replies[parentID]
+	for i, id := range siblings {
+		if id == reply.ID {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(dbStructure.Replies, parentID)
+	} else {
+		dbStructure.Replies[parentID] = siblings
+	}
+
+	parent, ok := dbStructure.Chirps[parentID]
+	if !ok {
+		return
+	}
+	if !reply.Deleted {
+		parent.ReplyCount--
+	}
+	if parent.Deleted && len(siblings) == 0 {
+		delete(dbStructure.Chirps, parentID)
+		if parent.InReplyToID != 0 {
+			dbStructure.removeReply(parent)
+		}
+		return
+	}
+	dbStructure.Chirps[parentID] = parent
+}
+
+// tombstone blanks out a deleted chirp that still has replies. Only its
+// place in the thread is kept.
+func (dbStructure *DBStructure) tombstone(chirp Chirp) {
+	dbStructure.Chirps[chirp.ID] = Chirp{
+		ID:          chirp.ID,
+		InReplyToID: chirp.InReplyToID,
+		ReplyCount:  chirp.ReplyCount,
+		CreatedAt:   chirp.CreatedAt,
+		UpdatedAt:   chirp.UpdatedAt,
+		Entities:    ChirpEntities{Hashtags: []HashtagEntity{}, Mentions: []MentionEntity{}},
+		Deleted:     true,
+	}
+	if parent, ok := dbStructure.Chirps[chirp.InReplyToID]; ok && chirp.InReplyToID != 0 && !parent.Deleted {
+		parent.ReplyCount--
+		dbStructure.Chirps[parent.ID] = parent
+	}
+}
+
+type ThreadChirp struct {
+	Chirp
+	Depth int `json:"depth"`
+}
+
+type Thread struct {
+	Ancestors   []Chirp
+	Chirp       Chirp
+	Descendants []ThreadChirp
+	Next        *PageKey
+	Prev        *PageKey
+}
+
+// GetThread returns the conversation around a chirp: its ancestors from the
+// root down, and one page of its descendants in the order they were posted.
+// Depth is relative to the requested chirp, so direct replies have depth 1.
+// Deleted chirps show up as tombstones, and chirps hidden from viewerID are
+// left out.
+func (db *DB) GetThread(id, viewerID int, q PageQuery) (Thread, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Thread{}, err
+	}
+	chirp, ok := dbStructure.Chirps[id]
+	if !ok {
+		return Thread{}, errors.New("chirp not found")
+	}
+
+	view := dbStructure.viewFilterFor(viewerID)
+	if view.hiddenAuthors[chirp.Author] || !view.canSee(chirp) {
+		return Thread{}, errors.New("chirp not found")
+	}
+
+	thread := Thread{Chirp: chirp, Ancestors: []Chirp{}}
+	seen := map[int]bool{id: true}
+	for parentID := chirp.InReplyToID; parentID != 0 && !seen[parentID]; {
+		parent, ok := dbStructure.Chirps[parentID]
+		if !ok {
+			break
+		}
+		seen[parentID] = true
+		if !view.hides(parent) {
+			thread.Ancestors = append(thread.Ancestors, parent)
+		}
+		parentID = parent.InReplyToID
+	}
+	for i, j := 0, len(thread.Ancestors)-1; i < j; i, j = i+1, j-1 {
+		thread.Ancestors[i], thread.Ancestors[j] = thread.Ancestors[j], thread.Ancestors[i]
+	}
+
+	depths := map[int]int{}
+	queue := []int{id}
+	for len(queue) > 0 {
+		parentID := queue[0]
+		queue = queue[1:]
+		for _, replyID := range dbStructure.Replies[parentID] {
+			if _, ok := depths[replyID]; ok {
+				continue
+			}
+			depths[replyID] = depths[parentID] + 1
+			queue = append(queue, replyID)
+		}
+	}
+	items := make([]PageKey, 0, len(depths))
+	for replyID := range depths {
+		if !view.hides(dbStructure.Chirps[replyID]) {
+			items = append(items, PageKey{ID: replyID, Key: int64(replyID)})
+		}
+	}
+	window, next, prev := paginate(items, q)
+
+	thread.Descendants = make([]ThreadChirp, 0, len(window))
+	for _, item := range window {
+		thread.Descendants = append(thread.Descendants, ThreadChirp{
+			Chirp: dbStructure.Chirps[item.ID],
+			Depth: depths[item.ID],
+		})
+	}
+	thread.Next = next
+	thread.Prev = prev
+	return thread, nil
+}
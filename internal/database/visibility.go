@@ -0,0 +1,111 @@
+package database
+
+import "errors"
+
+const (
+	// VisibilityPublic chirps can be read by anyone and show up everywhere.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted chirps can be read by anyone but are left out of
+	// global lists, search and hashtags. They still show up on their
+	// author's profile and in followers' timelines.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityFollowers chirps can only be read by the author's followers
+	// and the users they mention.
+	VisibilityFollowers = "followers"
+	// VisibilityDirect chirps can only be read by the users they mention.
+	VisibilityDirect = "direct"
+)
+
+// checkVisibility rejects unknown visibility levels. An empty visibility
+// means public.
+func checkVisibility(visibility string) error {
+	switch visibility {
+	case "", VisibilityPublic, VisibilityUnlisted, VisibilityFollowers, VisibilityDirect:
+		return nil
+	}
+	return errors.New("invalid visibility")
+}
+
+// ensureVisibility makes chirps stored before visibility levels existed
+// public.
+func (db *DB) ensureVisibility() error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.Visibility != "" {
+			continue
+		}
+		chirp.Visibility = VisibilityPublic
+		dbStructure.Chirps[id] = chirp
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return db.writeDB(dbStructure)
+}
+
+// mentions reports whether userID is mentioned in the chirp.
+func (c Chirp) mentions(userID int) bool {
+	for _, mention := range c.Entities.Mentions {
+		if mention.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// canSee reports whether the chirp's visibility lets the viewer read it.
+// Authors can always read their own chirps, and mentioned users can read
+// followers-only chirps too, so they can see what they were mentioned in.
+func (view viewFilter) canSee(chirp Chirp) bool {
+	if view.viewerID != 0 && chirp.Author == view.viewerID {
+		return true
+	}
+	switch chirp.Visibility {
+	case "", VisibilityPublic, VisibilityUnlisted:
+		return true
+	case VisibilityFollowers:
+		_, follows := view.following[chirp.Author]
+		return view.viewerID != 0 && (follows || chirp.mentions(view.viewerID))
+	case VisibilityDirect:
+		return view.viewerID != 0 && chirp.mentions(view.viewerID)
+	}
+	return false
+}
+
+// listed reports whether the chirp belongs in global lists such as the
+// chirp feed and search results. Unlisted and direct chirps are left out,
+// except for their authors.
+func (view viewFilter) listed(chirp Chirp) bool {
+	if view.viewerID != 0 && chirp.Author == view.viewerID {
+		return true
+	}
+	switch chirp.Visibility {
+	case VisibilityUnlisted, VisibilityDirect:
+		return false
+	}
+	return true
+}
+
+// CanView reports whether viewerID may read the chirp: its visibility allows
+// it and neither user has blocked the other, nor has viewerID muted its
+// author. Keyword filters don't apply, since the chirp was asked for.
+func (db *DB) CanView(viewerID int, chirp Chirp) (bool, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	return dbStructure.canView(viewerID, chirp), nil
+}
+
+func (dbStructure *DBStructure) canView(viewerID int, chirp Chirp) bool {
+	view := dbStructure.viewFilterFor(viewerID)
+	return !view.hiddenAuthors[chirp.Author] && view.canSee(chirp)
+}
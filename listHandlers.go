@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// listResponse is a list along with the URLs it can be shared by. The
+// URLs of private lists only work for their owner.
+type listResponse struct {
+	database.List
+	URL         string `json:"url"`
+	TimelineURL string `json:"timeline_url"`
+}
+
+func (c *apiConfig) presentList(list database.List) listResponse {
+	url := fmt.Sprintf("%s/api/lists/%d", c.baseURL, list.ID)
+	return listResponse{List: list, URL: url, TimelineURL: url + "/chirps"}
+}
+
+func (c *apiConfig) presentLists(lists []database.List) []listResponse {
+	responses := make([]listResponse, 0, len(lists))
+	for _, list := range lists {
+		responses = append(responses, c.presentList(list))
+	}
+	return responses
+}
+
+// respondWithListError reports why a list couldn't be found or changed.
+func respondWithListError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "list not found":
+		respondWithError(w, http.StatusNotFound, "List not found")
+	case "invalid list name":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("List names must be 1 to %d characters", database.MaxListNameLength))
+	case "list description too long":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("List descriptions can be at most %d characters", database.MaxListDescriptionLength))
+	case "user not found":
+		respondWithError(w, http.StatusBadRequest, "User does not exist")
+	case "blocked":
+		respondWithError(w, http.StatusForbidden, "Cannot interact with this user")
+	case "too many members":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A list can have at most %d members", database.MaxListMembers))
+	default:
+		log.Printf("Error updating list %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating list")
+	}
+}
+
+// readListRequest reads a list's settings from the request body. It writes
+// the error response and returns false if the body can't be read.
+func readListRequest(w http.ResponseWriter, r *http.Request) (database.List, bool) {
+	defer r.Body.Close()
+	type requestBody struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Private     bool   `json:"private"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return database.List{}, false
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return database.List{}, false
+	}
+	return database.List{Name: rBody.Name, Description: rBody.Description, Private: rBody.Private}, true
+}
+
+// readListMembers reads the user_ids of a bulk membership change.
+func readListMembers(w http.ResponseWriter, r *http.Request) ([]int, bool) {
+	defer r.Body.Close()
+	type requestBody struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return nil, false
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return nil, false
+	}
+	if len(rBody.UserIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "user_ids is required")
+		return nil, false
+	}
+	return rBody.UserIDs, true
+}
+
+func (c *apiConfig) handlePostList(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := readListRequest(w, r)
+	if !ok {
+		return
+	}
+	list.OwnerID = tokenClaims.Id
+
+	list, err = c.DB.CreateList(list)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, c.presentList(list))
+}
+
+// handleGetMyLists returns the authenticated user's lists, private ones
+// included.
+func (c *apiConfig) handleGetMyLists(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	lists, err := c.DB.GetLists(tokenClaims.Id, tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting lists %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting lists")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c.presentLists(lists))
+}
+
+// handleGetUserLists returns the public lists of the user in the path.
+func (c *apiConfig) handleGetUserLists(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	lists, err := c.DB.GetLists(id, c.optionalViewer(r))
+	if err != nil {
+		log.Printf("Error getting lists %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting lists")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c.presentLists(lists))
+}
+
+func (c *apiConfig) handleGetList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	list, err := c.DB.GetList(id, c.optionalViewer(r))
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c.presentList(list))
+}
+
+// handlePutList replaces the name, description and privacy of one of the
+// user's lists. Fields left out of the body are reset, not kept.
+func (c *apiConfig) handlePutList(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	list, ok := readListRequest(w, r)
+	if !ok {
+		return
+	}
+	list.ID = id
+	list.OwnerID = tokenClaims.Id
+
+	list, err = c.DB.UpdateList(list)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c.presentList(list))
+}
+
+func (c *apiConfig) handleDeleteList(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.DeleteList(id, tokenClaims.Id)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "List deleted")
+}
+
+// handleGetListMembers pages through a list's members. The total is sent in
+// X-Total-Count, as for followers.
+func (c *apiConfig) handleGetListMembers(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	viewerID := c.optionalViewer(r)
+	list, err := c.DB.GetList(id, viewerID)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+	page, err := c.DB.ListListMembers(id, viewerID, pageQuery)
+	if err != nil {
+		respondWithListError(w, err)
+		return
+	}
+
+	users, err := c.presentUsers(viewerID, page.Users)
+	if err != nil {
+		log.Printf("Error presenting list members %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting list members")
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(list.MemberCount))
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// listMembersHandler builds a handler that adds or removes the user_ids in
+// the request body to or from the list in the path, in one go.
+func (c *apiConfig) listMembersHandler(change func(id, ownerID int, userIDs []int) (database.List, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		userIDs, ok := readListMembers(w, r)
+		if !ok {
+			return
+		}
+
+		list, err := change(id, tokenClaims.Id, userIDs)
+		if err != nil {
+			respondWithListError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, c.presentList(list))
+	}
+}
+
+func (c *apiConfig) handleGetListChirps(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	viewerID := c.optionalViewer(r)
+	page, err := c.DB.GetListTimeline(id, viewerID, pageQuery)
+	if err != nil {
+		if err.Error() == "list not found" {
+			respondWithError(w, http.StatusNotFound, "List not found")
+			return
+		}
+		log.Printf("Error getting list timeline %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting chirps")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, viewerID, page.Chirps)
+}
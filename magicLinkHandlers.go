@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+func (c *apiConfig) handleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	if !c.magicLinkEnabled {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Email string `json:"email"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	if rBody.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	user, err := c.DB.GetUserByEmail(rBody.Email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			// same response as a real send so emails can't be enumerated
+			respondWithJSON(w, http.StatusAccepted, "Magic link sent")
+			return
+		}
+		log.Printf("Error getting user %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		return
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		log.Printf("Error generating magic link id %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error generating magic link")
+		return
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Id:        jti,
+		ExpiresAt: time.Now().Add(magicLinkTTL).Unix(),
+		Issuer:    "chirpy-magic",
+		IssuedAt:  time.Now().Unix(),
+		Subject:   fmt.Sprint(user.ID),
+	})
+	tokenString, err := token.SignedString([]byte(c.jwtSecret))
+	if err != nil {
+		log.Printf("Error signing token %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error signing token")
+		return
+	}
+
+	link := fmt.Sprintf("%s/api/login/magic/consume?token=%s", c.baseURL, url.QueryEscape(tokenString))
+	body := fmt.Sprintf("Use the link below to log in to Chirpy. It expires in %d minutes and can only be used once.\n\n%s", int(magicLinkTTL.Minutes()), link)
+	err = c.mailer.Send(user.Email, "Your Chirpy login link", body)
+	if err != nil {
+		log.Printf("Error sending magic link %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error sending magic link")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, "Magic link sent")
+}
+
+// magicLinkConfirmPage is what the emailed link opens. Mail scanners and
+// link prefetchers follow links with GET, so the link itself only shows a
+// button, and the token is used up by the POST it sends.
+var magicLinkConfirmPage = template.Must(template.New("magic").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in to Chirpy</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Log in to Chirpy</button>
+</form>
+</body>
+</html>
+`))
+
+// handleMagicLinkConfirm shows the page the emailed link leads to. It
+// doesn't look at the token, so opening the link never uses it up.
+func (c *apiConfig) handleMagicLinkConfirm(w http.ResponseWriter, r *http.Request) {
+	if !c.magicLinkEnabled {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err := magicLinkConfirmPage.Execute(w, struct{ Action, Token string }{r.URL.Path, token})
+	if err != nil {
+		log.Printf("Error rendering magic link page %s", err)
+	}
+}
+
+// handleMagicLinkConsume uses up a magic link and logs its user in. The token
+// comes as JSON from a frontend or as a form field from the confirmation page.
+func (c *apiConfig) handleMagicLinkConsume(w http.ResponseWriter, r *http.Request) {
+	if !c.magicLinkEnabled {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	defer r.Body.Close()
+	token := ""
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		token = r.PostFormValue("token")
+	} else {
+		type requestBody struct {
+			Token string `json:"token"`
+		}
+		rBody := requestBody{}
+		err := json.NewDecoder(r.Body).Decode(&rBody)
+		if err != nil && err != io.EOF {
+			log.Printf("Error unmarshalling JSON %s", err)
+			respondWithError(w, http.StatusBadRequest, "Error unmarshalling JSON")
+			return
+		}
+		token = rBody.Token
+	}
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	claims := &jwt.StandardClaims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(c.jwtSecret), nil
+	})
+	if err != nil {
+		log.Printf("Error parsing token %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired link")
+		return
+	}
+	if !tkn.Valid || claims.Issuer != "chirpy-magic" || claims.Id == "" {
+		log.Printf("Token is not a valid magic link")
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired link")
+		return
+	}
+
+	err = c.DB.ConsumeMagicLink(claims.Id, claims.ExpiresAt)
+	if err != nil {
+		if err.Error() == "magic link already used" {
+			if userID, err := strconv.Atoi(claims.Subject); err == nil {
+				c.recordSecurityEvent(r, userID, "login", "magic_link", "failure", "link already used")
+			}
+			respondWithError(w, http.StatusUnauthorized, "Link has already been used")
+			return
+		}
+		log.Printf("Error consuming magic link %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error consuming magic link")
+		return
+	}
+
+	user, err := c.DB.GetUser(claims.Subject)
+	if err != nil {
+		log.Printf("Error getting user %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		return
+	}
+
+	c.respondWithLogin(w, r, user, "magic_link")
+}
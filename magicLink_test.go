@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingMailer keeps the emails it is asked to send.
+type recordingMailer struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+func TestMagicLinkConsume(t *testing.T) {
+	c := newTestConfig(t)
+	mailer := &recordingMailer{}
+	c.mailer = mailer
+	c.magicLinkEnabled = true
+	if _, err := c.DB.CreateUser("alice@example.com", "hash"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	router := getApiRouter(c)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodPost, "/login/magic", strings.NewReader(`{"email":"alice@example.com"}`)))
+	if rec.Code != http.StatusAccepted || len(mailer.bodies) != 1 {
+		t.Fatalf("request link: status %d, %d emails", rec.Code, len(mailer.bodies))
+	}
+	link, err := url.Parse(regexp.MustCompile(`\S+/api(/login/magic/consume\S+)`).FindStringSubmatch(mailer.bodies[0])[1])
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	token := link.Query().Get("token")
+
+	// scanners opening the link, however often, don't use it up
+	for i := 0; i < 2; i++ {
+		rec = serve(httptest.NewRequest(http.MethodGet, link.String(), nil))
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Fatalf("open link: status %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(rec.Body.String(), `method="post"`) || !strings.Contains(rec.Body.String(), `value="`+token+`"`) {
+			t.Fatalf("confirmation page has no form for the token: %s", rec.Body)
+		}
+		if strings.Contains(rec.Body.String(), `"token":`) {
+			t.Fatalf("opening the link logged in: %s", rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "missing token", contentType: "application/json", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "bad token", contentType: "application/json", body: `{"token":"nope"}`, wantStatus: http.StatusUnauthorized},
+		{name: "confirmation form", contentType: "application/x-www-form-urlencoded", body: url.Values{"token": {token}}.Encode(), wantStatus: http.StatusOK},
+		{name: "used link", contentType: "application/json", body: `{"token":"` + token + `"}`, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login/magic/consume", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := serve(req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(rec.Body.String(), `"token":`) {
+				t.Errorf("no access token: %s", rec.Body)
+			}
+		})
+	}
+}
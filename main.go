@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
@@ -15,8 +18,39 @@ func main() {
 	godotenv.Load()
 	jwtSecret := os.Getenv("JWT_SECRET")
 	polkaApiKey := os.Getenv("POLKA_KEY")
+	magicLinkEnabled := os.Getenv("MAGIC_LINK_ENABLED") == "true"
+	sessionMode := os.Getenv("SESSION_COOKIES") == "true"
+	allowedOrigins := map[string]bool{}
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			allowedOrigins[origin] = true
+		}
+	}
+	moderators := map[int]bool{}
+	for _, id := range strings.Split(os.Getenv("MODERATOR_IDS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			log.Fatalf("Invalid MODERATOR_IDS entry %q", id)
+		}
+		moderators[n] = true
+	}
 	const filepathRoot = "."
 	const port = "8080"
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	// session cookies are only sent over https unless told otherwise, so a
+	// plain http BASE_URL, as in local development, turns Secure off
+	secureCookies := strings.HasPrefix(baseURL, "https://")
+	if v := os.Getenv("SESSION_COOKIES_SECURE"); v != "" {
+		secureCookies = v == "true"
+	}
 	dbg := flag.Bool("debug", false, "Enable debug mode")
 	flag.Parse()
 	if *dbg {
@@ -32,7 +66,30 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	apiConfig := apiConfig{fileserverHitCount: 0, filepathRoot: filepathRoot, DB: db, jwtSecret: jwtSecret, polkaApiKey:polkaApiKey}
+	timelineMode := os.Getenv("TIMELINE_FANOUT")
+	if timelineMode == "" {
+		timelineMode = database.FanOutOnRead
+	}
+	err = db.SetTimelineMode(timelineMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mailer := newMailerFromEnv()
+	apiConfig := apiConfig{fileserverHitCount: 0, filepathRoot: filepathRoot, DB: db, jwtSecret: jwtSecret, polkaApiKey:polkaApiKey, baseURL: baseURL, mailer: mailer, notifier: newNotifierFromEnv(mailer), magicLinkEnabled: magicLinkEnabled, oidc: newOIDCProviderFromEnv(baseURL), sessionMode: sessionMode, secureCookies: secureCookies, allowedOrigins: allowedOrigins, contentFilter: newContentFilterFromEnv(), moderators: moderators, blobStore: newBlobStoreFromEnv(), maxMediaBytes: defaultMaxMediaBytes}
+	if s := os.Getenv("MEDIA_MAX_BYTES"); s != "" {
+		apiConfig.maxMediaBytes, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || apiConfig.maxMediaBytes <= 0 {
+			log.Fatalf("Invalid MEDIA_MAX_BYTES %q", s)
+		}
+	}
+	schedulerInterval := defaultSchedulerInterval
+	if s := os.Getenv("SCHEDULER_INTERVAL"); s != "" {
+		schedulerInterval, err = time.ParseDuration(s)
+		if err != nil || schedulerInterval <= 0 {
+			log.Fatalf("Invalid SCHEDULER_INTERVAL %q", s)
+		}
+	}
+	go apiConfig.runScheduler(schedulerInterval)
 	fsHandler := apiConfig.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(apiConfig.filepathRoot))))
 
 	r := chi.NewRouter()
@@ -42,7 +99,7 @@ func main() {
 	r.Mount("/admin", getAdminRouter(&apiConfig))
 	r.Handle("/app", fsHandler)
 	r.Handle("/app/*", fsHandler)
-	corsMux := middlewareCors(r)
+	corsMux := apiConfig.middlewareCors(middlewareCSRF(r))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	defaultMaxMediaBytes = 5 << 20
+	// maxMediaPixels keeps small files that decode into huge images from
+	// exhausting memory.
+	maxMediaPixels = 40_000_000
+	// maxGIFFrames bounds the frames of an animated GIF, which are all
+	// decoded at once.
+	maxGIFFrames = 500
+	jpegQuality  = 85
+)
+
+// thumbnailSizes are the bounding boxes of the thumbnails made for each
+// upload. Only thumbnails smaller than the original are made.
+var thumbnailSizes = []struct {
+	name string
+	size int
+}{
+	{"small", 150},
+	{"medium", 400},
+	{"large", 1024},
+}
+
+var errUnsupportedMedia = errors.New("unsupported media type")
+
+// processedImage is an upload ready to be stored: the original re-encoded
+// without its metadata, and its thumbnails.
+type processedImage struct {
+	variants map[string]encodedImage
+}
+
+type encodedImage struct {
+	contentType   string
+	width, height int
+	data          []byte
+}
+
+// processImage checks that data is a JPEG, PNG or GIF image and prepares it
+// for storage. Re-encoding drops everything but the pixels, so EXIF data
+// such as GPS positions, comments and other metadata chunks are stripped.
+// A JPEG's EXIF orientation is applied first so it still displays the right
+// way up. Animated GIFs keep their frames; their thumbnails are stills.
+func processImage(data []byte) (processedImage, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return processedImage{}, errUnsupportedMedia
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return processedImage{}, errUnsupportedMedia
+	}
+	if config.Width*config.Height > maxMediaPixels {
+		return processedImage{}, errors.New("image too large")
+	}
+
+	var original encodedImage
+	var still image.Image
+	switch contentType {
+	case "image/gif":
+		// DecodeAll allocates every frame, so count them before decoding
+		frames, pixels, err := gifFrames(data)
+		if err != nil {
+			return processedImage{}, errUnsupportedMedia
+		}
+		if frames > maxGIFFrames || pixels > maxMediaPixels {
+			return processedImage{}, errors.New("image too large")
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return processedImage{}, errUnsupportedMedia
+		}
+		// the decoder skips comment and application extensions other
+		// than the loop count, so they're gone once it's encoded again
+		buf := bytes.Buffer{}
+		err = gif.EncodeAll(&buf, anim)
+		if err != nil {
+			return processedImage{}, err
+		}
+		original = encodedImage{contentType: contentType, width: anim.Config.Width, height: anim.Config.Height, data: buf.Bytes()}
+		still = firstFrame(anim)
+	default:
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return processedImage{}, errUnsupportedMedia
+		}
+		if contentType == "image/jpeg" {
+			img = applyOrientation(img, jpegOrientation(data))
+		}
+		original, err = encodeImage(img, contentType)
+		if err != nil {
+			return processedImage{}, err
+		}
+		still = img
+	}
+
+	processed := processedImage{variants: map[string]encodedImage{"original": original}}
+	thumbnailType := contentType
+	if thumbnailType == "image/gif" {
+		thumbnailType = "image/png"
+	}
+	for _, thumbnail := range thumbnailSizes {
+		width, height := fitWithin(original.width, original.height, thumbnail.size)
+		if width >= original.width && height >= original.height {
+			break
+		}
+		encoded, err := encodeImage(resizeImage(still, width, height), thumbnailType)
+		if err != nil {
+			return processedImage{}, err
+		}
+		processed.variants[thumbnail.name] = encoded
+	}
+	return processed, nil
+}
+
+// gifFrames walks the blocks of a GIF without decoding any pixels and
+// returns how many frames it has and their total area.
+func gifFrames(data []byte) (frames, pixels int, err error) {
+	errTruncated := errors.New("truncated gif")
+	// colorTable returns the size of the color table a packed field
+	// announces, if any
+	colorTable := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+	// skipSubBlocks returns the position after the data sub-blocks at i
+	skipSubBlocks := func(i int) (int, error) {
+		for {
+			if i >= len(data) {
+				return 0, errTruncated
+			}
+			size := int(data[i])
+			i++
+			if size == 0 {
+				return i, nil
+			}
+			i += size
+		}
+	}
+
+	if len(data) < 13 {
+		return 0, 0, errTruncated
+	}
+	i := 13 + colorTable(data[10])
+	for {
+		if i >= len(data) {
+			return 0, 0, errTruncated
+		}
+		switch data[i] {
+		case 0x3B: // trailer
+			return frames, pixels, nil
+		case 0x21: // extension: label, then sub-blocks
+			i, err = skipSubBlocks(i + 2)
+			if err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return 0, 0, errTruncated
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			frames++
+			pixels += width * height
+			// LZW minimum code size, then the image data sub-blocks
+			i, err = skipSubBlocks(i + 10 + colorTable(data[i+9]) + 1)
+			if err != nil {
+				return 0, 0, err
+			}
+		default:
+			return 0, 0, errors.New("unknown gif block")
+		}
+	}
+}
+
+func encodeImage(img image.Image, contentType string) (encodedImage, error) {
+	buf := bytes.Buffer{}
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return encodedImage{}, err
+	}
+	bounds := img.Bounds()
+	return encodedImage{contentType: contentType, width: bounds.Dx(), height: bounds.Dy(), data: buf.Bytes()}, nil
+}
+
+// firstFrame renders the first frame of a GIF onto its full canvas.
+func firstFrame(anim *gif.GIF) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	if len(anim.Image) > 0 {
+		draw.Draw(canvas, anim.Image[0].Bounds(), anim.Image[0], anim.Image[0].Bounds().Min, draw.Over)
+	}
+	return canvas
+}
+
+// fitWithin scales width and height down to fit in a size by size box,
+// keeping the aspect ratio.
+func fitWithin(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
+
+// resizeImage shrinks img to width by height by averaging the source pixels
+// that fall on each destination pixel, which avoids the aliasing of nearest
+// neighbour sampling when scaling down a lot.
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			offset := y*dst.Stride + x*4
+			for i := range sum {
+				dst.Pix[offset+i] = uint8((sum[i] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, from 1 to 8, or 1
+// when it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		// the image data starts at SOS and metadata segments come before it
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation flips and rotates img so it displays upright without its
+// EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
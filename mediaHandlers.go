@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type mediaVariantResponse struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+}
+
+// mediaResponse is an upload as shown to clients, with URLs in place of the
+// blob store keys.
+type mediaResponse struct {
+	ID        int                             `json:"id"`
+	ChirpID   int                             `json:"chirp_id,omitempty"`
+	Variants  map[string]mediaVariantResponse `json:"variants"`
+	CreatedAt time.Time                       `json:"created_at"`
+}
+
+// attachmentResponse is media attached to a chirp, with the URLs of the
+// original and its thumbnails.
+type attachmentResponse struct {
+	database.Attachment
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+type attachmentRequest struct {
+	ID      int    `json:"id"`
+	AltText string `json:"alt_text"`
+}
+
+func (c *apiConfig) mediaURL(mediaID int, variant string) string {
+	return fmt.Sprintf("%s/api/media/%d/%s", c.baseURL, mediaID, variant)
+}
+
+func (c *apiConfig) presentMedia(media database.Media) mediaResponse {
+	variants := map[string]mediaVariantResponse{}
+	for name, variant := range media.Variants {
+		variants[name] = mediaVariantResponse{
+			URL:         c.mediaURL(media.ID, name),
+			ContentType: variant.ContentType,
+			Width:       variant.Width,
+			Height:      variant.Height,
+			Size:        variant.Size,
+		}
+	}
+	return mediaResponse{ID: media.ID, ChirpID: media.ChirpID, Variants: variants, CreatedAt: media.CreatedAt}
+}
+
+func (c *apiConfig) presentAttachments(attachments []database.Attachment) []attachmentResponse {
+	if len(attachments) == 0 {
+		return nil
+	}
+	responses := make([]attachmentResponse, 0, len(attachments))
+	for _, attachment := range attachments {
+		thumbnails := map[string]string{}
+		for _, variant := range attachment.Variants {
+			thumbnails[variant] = c.mediaURL(attachment.MediaID, variant)
+		}
+		responses = append(responses, attachmentResponse{
+			Attachment: attachment,
+			URL:        c.mediaURL(attachment.MediaID, "original"),
+			Thumbnails: thumbnails,
+		})
+	}
+	return responses
+}
+
+// toAttachments turns the attachments of a chirp or draft request into the
+// form the database checks and fills in.
+func toAttachments(requests []attachmentRequest) []database.Attachment {
+	attachments := []database.Attachment{}
+	for _, request := range requests {
+		attachments = append(attachments, database.Attachment{MediaID: request.ID, AltText: request.AltText})
+	}
+	return attachments
+}
+
+// respondWithAttachmentError reports why a chirp's attachments were refused.
+// It returns false if err isn't about attachments.
+func respondWithAttachmentError(w http.ResponseWriter, err error) bool {
+	switch err.Error() {
+	case "too many attachments":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A chirp can have at most %d attachments", database.MaxAttachments))
+	case "media not found":
+		respondWithError(w, http.StatusBadRequest, "Attachment does not exist")
+	case "media attached":
+		respondWithError(w, http.StatusConflict, "Media is already attached to a chirp")
+	case "alt text too long":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Alt text can be at most %d characters", database.MaxAltTextLength))
+	default:
+		return false
+	}
+	return true
+}
+
+// handlePostMedia accepts an image as the "file" field of a multipart form.
+// The image is cleaned and thumbnailed before anything is stored, and the
+// returned ID can then be attached to a chirp.
+func (c *apiConfig) handlePostMedia(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// leave room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, c.maxMediaBytes+64<<10)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Media can be at most %d bytes", c.maxMediaBytes))
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart form with a file field")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, c.maxMediaBytes+1))
+	if err != nil {
+		log.Printf("Error reading upload %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading upload")
+		return
+	}
+	if int64(len(data)) > c.maxMediaBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Media can be at most %d bytes", c.maxMediaBytes))
+		return
+	}
+
+	processed, err := processImage(data)
+	if err != nil {
+		if errors.Is(err, errUnsupportedMedia) {
+			respondWithError(w, http.StatusUnsupportedMediaType, "Only JPEG, PNG and GIF images are supported")
+			return
+		}
+		if err.Error() == "image too large" {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Images can be at most %d pixels", maxMediaPixels))
+			return
+		}
+		log.Printf("Error processing image %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error processing image")
+		return
+	}
+
+	// keys are random so they don't depend on the media ID, which is only
+	// known once the record is saved after the blobs
+	prefix, err := randomToken(16)
+	if err != nil {
+		log.Printf("Error generating media key %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error storing media")
+		return
+	}
+	media := database.Media{OwnerID: tokenClaims.Id, Variants: map[string]database.MediaVariant{}}
+	for name, variant := range processed.variants {
+		key := prefix + "/" + name
+		err = c.blobStore.Put(key, variant.contentType, variant.data)
+		if err != nil {
+			log.Printf("Error storing media %s", err)
+			c.deleteMediaBlobs(media)
+			respondWithError(w, http.StatusInternalServerError, "Error storing media")
+			return
+		}
+		media.Variants[name] = database.MediaVariant{
+			Key:         key,
+			ContentType: variant.contentType,
+			Width:       variant.width,
+			Height:      variant.height,
+			Size:        len(variant.data),
+		}
+	}
+
+	saved, err := c.DB.CreateMedia(media)
+	if err != nil {
+		log.Printf("Error creating media %s", err)
+		c.deleteMediaBlobs(media)
+		respondWithError(w, http.StatusInternalServerError, "Error storing media")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, c.presentMedia(saved))
+}
+
+// deleteMediaBlobs removes the stored variants of media. Failures are only
+// logged, since the media record is gone either way.
+func (c *apiConfig) deleteMediaBlobs(media database.Media) {
+	for _, variant := range media.Variants {
+		err := c.blobStore.Delete(variant.Key)
+		if err != nil {
+			log.Printf("Error deleting blob %s %s", variant.Key, err)
+		}
+	}
+}
+
+func (c *apiConfig) handleGetMedia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	media, err := c.DB.GetMedia(id, c.optionalViewer(r))
+	if err != nil {
+		if err.Error() == "media not found" {
+			respondWithError(w, http.StatusNotFound, "Media not found")
+			return
+		}
+		log.Printf("Error getting media %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting media")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, c.presentMedia(media))
+}
+
+// handleGetMediaFile serves the bytes of one variant of an upload. They go
+// through the API rather than straight from the blob store so that media on
+// followers-only and direct chirps stays private.
+func (c *apiConfig) handleGetMediaFile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	media, err := c.DB.GetMedia(id, c.optionalViewer(r))
+	if err != nil {
+		if err.Error() == "media not found" {
+			respondWithError(w, http.StatusNotFound, "Media not found")
+			return
+		}
+		log.Printf("Error getting media %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting media")
+		return
+	}
+	variant, ok := media.Variants[r.PathValue("variant")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+	blob, err := c.blobStore.Get(variant.Key)
+	if err != nil {
+		log.Printf("Error reading blob %s %s", variant.Key, err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting media")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", variant.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(variant.Size))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+func (c *apiConfig) handleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	media, err := c.DB.DeleteMedia(id, tokenClaims.Id)
+	if err != nil {
+		if err.Error() == "media not found" {
+			respondWithError(w, http.StatusNotFound, "Media not found")
+			return
+		}
+		if err.Error() == "media attached" {
+			respondWithError(w, http.StatusConflict, "Media is in use by a chirp or as your avatar")
+			return
+		}
+		log.Printf("Error deleting media %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting media")
+		return
+	}
+	c.deleteMediaBlobs(media)
+	respondWithJSON(w, http.StatusOK, "Media deleted")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withEXIF inserts an APP1 segment right after the JPEG's SOI marker, with
+// an IFD0 holding the orientation tag and some trailing text standing in
+// for GPS data.
+func withEXIF(jpg []byte, orientation uint16, text string) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, text...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+// withTextChunk inserts a tEXt chunk right after the PNG's IHDR chunk.
+func withTextChunk(p []byte, text string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	// 8 byte signature, then IHDR: length, type, 13 bytes of data, CRC
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	out := append([]byte{}, p[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, p[ihdrEnd:]...)
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+func testGIF(frames, width, height int) []byte {
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	buf := bytes.Buffer{}
+	gif.EncodeAll(&buf, anim)
+	return buf.Bytes()
+}
+
+func TestProcessImage(t *testing.T) {
+	jpg := bytes.Buffer{}
+	jpeg.Encode(&jpg, testImage(400, 200), nil)
+	pngData := bytes.Buffer{}
+	png.Encode(&pngData, testImage(300, 200))
+
+	tests := []struct {
+		name string
+		data []byte
+		// secret must not survive processing
+		secret                string
+		wantErr               string
+		wantType              string
+		wantWidth, wantHeight int
+		wantVariants          []string
+	}{
+		{
+			name:     "jpeg exif is stripped and its orientation applied",
+			data:     withEXIF(jpg.Bytes(), 6, "GPS 48.8584 N 2.2945 E"),
+			secret:   "GPS 48.8584",
+			wantType: "image/jpeg", wantWidth: 200, wantHeight: 400,
+			wantVariants: []string{"original", "small"},
+		},
+		{
+			name:     "jpeg without exif keeps its orientation",
+			data:     jpg.Bytes(),
+			wantType: "image/jpeg", wantWidth: 400, wantHeight: 200,
+			wantVariants: []string{"original", "small"},
+		},
+		{
+			name:     "png text chunks are stripped",
+			data:     withTextChunk(pngData.Bytes(), "Comment\x00taken at home"),
+			secret:   "taken at home",
+			wantType: "image/png", wantWidth: 300, wantHeight: 200,
+			wantVariants: []string{"original", "small"},
+		},
+		{
+			name:     "animated gif keeps its frames",
+			data:     testGIF(3, 500, 500),
+			wantType: "image/gif", wantWidth: 500, wantHeight: 500,
+			wantVariants: []string{"original", "small", "medium"},
+		},
+		{
+			name:    "gif with too many frames",
+			data:    testGIF(maxGIFFrames+1, 1, 1),
+			wantErr: "image too large",
+		},
+		{
+			name:    "gif frames too large in total",
+			data:    testGIF(maxMediaPixels/(2000*2000)+1, 2000, 2000),
+			wantErr: "image too large",
+		},
+		{
+			name:    "not an image",
+			data:    []byte("hello world, this is plain text"),
+			wantErr: errUnsupportedMedia.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := processImage(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("processImage: %v", err)
+			}
+			if len(processed.variants) != len(tt.wantVariants) {
+				t.Errorf("%d variants, want %v", len(processed.variants), tt.wantVariants)
+			}
+			for _, name := range tt.wantVariants {
+				variant, ok := processed.variants[name]
+				if !ok {
+					t.Errorf("missing variant %s", name)
+					continue
+				}
+				if tt.secret != "" && bytes.Contains(variant.data, []byte(tt.secret)) {
+					t.Errorf("variant %s still contains %q", name, tt.secret)
+				}
+				if bytes.Contains(variant.data, []byte("Exif\x00\x00")) {
+					t.Errorf("variant %s still has an EXIF segment", name)
+				}
+			}
+			original := processed.variants["original"]
+			if original.contentType != tt.wantType || original.width != tt.wantWidth || original.height != tt.wantHeight {
+				t.Errorf("original is %s %dx%d, want %s %dx%d", original.contentType, original.width, original.height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(original.data))
+			if err != nil || config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("original decodes as %dx%d (%v), want %dx%d", config.Width, config.Height, err, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	frames, pixels, err := gifFrames(testGIF(3, 20, 10))
+	if err != nil || frames != 3 || pixels != 600 {
+		t.Errorf("gifFrames = %d, %d, %v, want 3, 600, nil", frames, pixels, err)
+	}
+	data := testGIF(2, 20, 10)
+	if _, _, err := gifFrames(data[:len(data)-10]); err == nil {
+		t.Error("truncated gif was accepted")
+	}
+}
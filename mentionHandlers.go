@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+)
+
+func (c *apiConfig) handleGetMentions(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListMentions(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting mentions %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting mentions")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	c.respondWithChirps(w, http.StatusOK, tokenClaims.Id, page.Chirps)
+}
+
+func (c *apiConfig) handleGetUnreadMentionCount(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	unread, err := c.DB.CountUnreadMentions(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error counting mentions %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error counting mentions")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]int{"unread": unread})
+}
+
+func (c *apiConfig) handleMarkMentionsRead(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		UpToChirpId int `json:"up_to_chirp_id"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	if len(dat) > 0 {
+		err = json.Unmarshal(dat, &rBody)
+		if err != nil {
+			log.Printf("Error unmarshalling JSON %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+			return
+		}
+	}
+
+	err = c.DB.MarkMentionsRead(tokenClaims.Id, rBody.UpToChirpId)
+	if err != nil {
+		log.Printf("Error marking mentions read %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error marking mentions read")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Mentions marked as read")
+}
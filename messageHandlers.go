@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxMessageLength is the longest direct message, counted like chirps.
+const maxMessageLength = 1000
+
+// cleanMessageBody checks a direct message's length and runs it through
+// content moderation. It writes the error response and returns false if the
+// message can't be sent.
+func (c *apiConfig) cleanMessageBody(w http.ResponseWriter, body string) (string, bool) {
+	if body == "" {
+		respondWithError(w, http.StatusBadRequest, "Message body is required")
+		return "", false
+	}
+	if length := graphemeCount(body); length > maxMessageLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Message too long: %d characters, the limit is %d", length, maxMessageLength))
+		return "", false
+	}
+	content := c.moderate(contentMessage, body)
+	if content.rejected() {
+		hit := content.firstHit(moderationReject)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Message rejected by %s rule %q", hit.Stage, hit.Rule))
+		return "", false
+	}
+	return content.Text, true
+}
+
+// respondWithConversationError reports why a conversation action failed.
+func respondWithConversationError(w http.ResponseWriter, err error) {
+	switch err.Error() {
+	case "conversation not found":
+		respondWithError(w, http.StatusNotFound, "Conversation not found")
+	case "user not found":
+		respondWithError(w, http.StatusNotFound, "User not found")
+	case "no participants":
+		respondWithError(w, http.StatusBadRequest, "A conversation needs at least one other participant")
+	case "too many participants":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A conversation can have at most %d participants", database.MaxConversationSize))
+	case "blocked":
+		respondWithError(w, http.StatusForbidden, "Cannot interact with this user")
+	case "not accepting messages":
+		respondWithError(w, http.StatusForbidden, "This user isn't accepting messages from you")
+	default:
+		log.Printf("Error updating conversation %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating conversation")
+	}
+}
+
+// handlePostConversation starts a conversation, sending its first message
+// when a body is given.
+func (c *apiConfig) handlePostConversation(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		ParticipantIds []int  `json:"participant_ids"`
+		Body           string `json:"body"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	body := ""
+	if rBody.Body != "" {
+		var ok bool
+		body, ok = c.cleanMessageBody(w, rBody.Body)
+		if !ok {
+			return
+		}
+	}
+
+	conversation, err := c.DB.StartConversation(tokenClaims.Id, rBody.ParticipantIds)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	if body != "" {
+		_, err = c.DB.SendMessage(conversation.ID, tokenClaims.Id, body)
+		if err != nil {
+			respondWithConversationError(w, err)
+			return
+		}
+	}
+	summary, err := c.DB.GetConversation(conversation.ID, tokenClaims.Id)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, summary)
+}
+
+func (c *apiConfig) handleGetConversations(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListConversations(tokenClaims.Id, pageQuery)
+	if err != nil {
+		log.Printf("Error getting conversations %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting conversations")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, page.Conversations)
+}
+
+func (c *apiConfig) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	summary, err := c.DB.GetConversation(id, tokenClaims.Id)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, summary)
+}
+
+func (c *apiConfig) handleDeleteConversation(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.DeleteConversation(id, tokenClaims.Id)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Conversation deleted")
+}
+
+func (c *apiConfig) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListMessages(id, tokenClaims.Id, pageQuery)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, page.Messages)
+}
+
+func (c *apiConfig) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Body string `json:"body"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	body, ok := c.cleanMessageBody(w, rBody.Body)
+	if !ok {
+		return
+	}
+
+	message, err := c.DB.SendMessage(id, tokenClaims.Id, body)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, message)
+}
+
+func (c *apiConfig) handleMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.MarkConversationRead(id, tokenClaims.Id)
+	if err != nil {
+		respondWithConversationError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Conversation marked as read")
+}
+
+func (c *apiConfig) handleGetUnreadMessageCount(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	counts, err := c.DB.CountUnreadMessages(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error counting messages %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error counting messages")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, counts)
+}
+
+// handlePutMessagingSettings sets who can start conversations with the user.
+func (c *apiConfig) handlePutMessagingSettings(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		AllowDmsFrom string `json:"allow_dms_from"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+
+	err = c.DB.SetDMPolicy(tokenClaims.Id, rBody.AllowDmsFrom)
+	if err != nil {
+		if err.Error() == "unknown dm policy" {
+			respondWithError(w, http.StatusBadRequest, "allow_dms_from must be everyone or following")
+			return
+		}
+		log.Printf("Error updating messaging settings %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating messaging settings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"allow_dms_from": rBody.AllowDmsFrom})
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"internal/database"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	contentChirp   = "chirp"
+	contentEmail   = "email"
+	contentMessage = "message"
+	contentProfile = "profile"
+)
+
+const (
+	moderationCensor = "censor"
+	moderationReject = "reject"
+	moderationFlag   = "flag"
+)
+
+// moderatedContent is the text going through a moderation pipeline.
+// Normalized is a folded copy of Text that stages match against, so look-alike
+// characters can't sneak past them. It always has as many runes as Text, so
+// a match in one maps to the same runes of the other.
+type moderatedContent struct {
+	Kind       string
+	Text       string
+	Normalized string
+	Hits       []database.ModerationHit
+}
+
+func (c moderatedContent) rejected() bool {
+	return c.has(moderationReject)
+}
+
+func (c moderatedContent) flagged() bool {
+	return c.has(moderationFlag)
+}
+
+// flags returns the hits that should put the content in the moderation
+// queue.
+func (c moderatedContent) flags() []database.ModerationHit {
+	flags := []database.ModerationHit{}
+	for _, hit := range c.Hits {
+		if hit.Action == moderationFlag {
+			flags = append(flags, hit)
+		}
+	}
+	return flags
+}
+
+func (c moderatedContent) has(action string) bool {
+	for _, hit := range c.Hits {
+		if hit.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHit returns the first hit with the given action.
+func (c moderatedContent) firstHit(action string) database.ModerationHit {
+	for _, hit := range c.Hits {
+		if hit.Action == action {
+			return hit
+		}
+	}
+	return database.ModerationHit{}
+}
+
+// censor replaces the runes in [start, end) with asterisks, in both the text
+// and its normalized copy.
+func (c *moderatedContent) censor(start, end int) {
+	text := []rune(c.Text)
+	normalized := []rune(c.Normalized)
+	mask := []rune("****")
+	c.Text = string(text[:start]) + string(mask) + string(text[end:])
+	c.Normalized = string(normalized[:start]) + string(mask) + string(normalized[end:])
+}
+
+// ContentFilter is a stage of content moderation. Stages can censor the text
+// and record hits that reject it or flag it for review.
+type ContentFilter interface {
+	Filter(content *moderatedContent)
+}
+
+// moderationPipeline runs its stages in order and stops at the first stage
+// that rejects the content.
+type moderationPipeline []ContentFilter
+
+func (p moderationPipeline) Filter(content *moderatedContent) {
+	for _, stage := range p {
+		stage.Filter(content)
+		if content.rejected() {
+			return
+		}
+	}
+}
+
+// moderate runs text of the given kind through the content filter.
+func (c *apiConfig) moderate(kind, text string) moderatedContent {
+	content := moderatedContent{Kind: kind, Text: text, Normalized: text}
+	c.contentFilter.Filter(&content)
+	for _, hit := range content.Hits {
+		log.Printf("Moderation: %s rule %q of %s stage fired on %s", hit.Action, hit.Rule, hit.Stage, kind)
+	}
+	return content
+}
+
+// moderateEmail returns the rule that refuses email, if any. Emails can't be
+// censored, so censoring rules refuse them as well.
+func (c *apiConfig) moderateEmail(email string) (database.ModerationHit, bool) {
+	content := c.moderate(contentEmail, email)
+	if content.rejected() {
+		return content.firstHit(moderationReject), true
+	}
+	if content.has(moderationCensor) {
+		return content.firstHit(moderationCensor), true
+	}
+	return database.ModerationHit{}, false
+}
+
+// lookAlikes maps characters that are commonly used to disguise words to the
+// ASCII letters they resemble.
+var lookAlikes = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i',
+	'ї': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'á': 'a', 'à': 'a', 'â': 'a', 'ä': 'a', 'ã': 'a', 'å': 'a',
+	'é': 'e', 'è': 'e', 'ê': 'e', 'ë': 'e',
+	'í': 'i', 'ì': 'i', 'î': 'i', 'ï': 'i', 'ı': 'i',
+	'ó': 'o', 'ò': 'o', 'ô': 'o', 'ö': 'o', 'õ': 'o', 'ø': 'o',
+	'ú': 'u', 'ù': 'u', 'û': 'u', 'ü': 'u',
+	'ñ': 'n', 'ç': 'c', 'ý': 'y', 'ÿ': 'y',
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't',
+	'$': 's',
+}
+
+// normalizeStage folds case, fullwidth forms and look-alike characters into
+// the normalized copy of the text. It never changes the text itself.
+type normalizeStage struct{}
+
+func (normalizeStage) Filter(content *moderatedContent) {
+	content.Normalized = normalizeText(content.Normalized)
+}
+
+// normalizeText folds each rune of s on its own, so the result has as many
+// runes as s.
+func normalizeText(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		// fullwidth ASCII variants
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		r = unicode.ToLower(r)
+		if folded, ok := lookAlikes[r]; ok {
+			r = folded
+		}
+		runes[i] = r
+	}
+	return string(runes)
+}
+
+// watchedFile holds the parsed contents of a configuration file and parses
+// it again whenever the file changes on disk.
+type watchedFile[T any] struct {
+	path    string
+	parse   func(lines []string) (T, error)
+	mu      sync.Mutex
+	modTime time.Time
+	value   T
+}
+
+func (f *watchedFile[T]) get() T {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil || !info.ModTime().After(f.modTime) {
+		return f.value
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		log.Printf("Error reading %s %s", f.path, err)
+		return f.value
+	}
+	defer file.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	value, err := f.parse(lines)
+	if err != nil {
+		log.Printf("Error loading %s %s", f.path, err)
+		return f.value
+	}
+	f.modTime = info.ModTime()
+	f.value = value
+	log.Printf("Loaded %s", f.path)
+	return f.value
+}
+
+func parseAction(action string) (string, error) {
+	switch action {
+	case moderationCensor, moderationReject, moderationFlag:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown moderation action %q", action)
+}
+
+// wordListStage censors, rejects or flags listed words. Words are matched
+// whole against the normalized text, ignoring punctuation around them.
+type wordListStage struct {
+	words func() map[string]string
+}
+
+var defaultWordList = map[string]string{
+	"kerfuffle": moderationCensor,
+	"sharbert":  moderationCensor,
+	"fornax":    moderationCensor,
+}
+
+// newWordListStage loads the word list from path, one word per line,
+// optionally followed by the action to take. Without a path it falls back to
+// the built-in list.
+func newWordListStage(path string) wordListStage {
+	if path == "" {
+		return wordListStage{words: func() map[string]string { return defaultWordList }}
+	}
+	file := &watchedFile[map[string]string]{
+		path:  path,
+		value: map[string]string{},
+		parse: func(lines []string) (map[string]string, error) {
+			words := map[string]string{}
+			for _, line := range lines {
+				fields := strings.Fields(line)
+				action := moderationCensor
+				if len(fields) > 1 {
+					var err error
+					action, err = parseAction(fields[1])
+					if err != nil {
+						return nil, err
+					}
+				}
+				words[normalizeText(fields[0])] = action
+			}
+			return words, nil
+		},
+	}
+	return wordListStage{words: file.get}
+}
+
+func (s wordListStage) Filter(content *moderatedContent) {
+	words := s.words()
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsNumber(r)
+	}
+	type span struct{ start, end int }
+	censored := []span{}
+	runes := []rune(content.Normalized)
+	for start := 0; start < len(runes); {
+		if !isWord(runes[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWord(runes[end]) {
+			end++
+		}
+		word := string(runes[start:end])
+		if action, ok := words[word]; ok {
+			content.Hits = append(content.Hits, database.ModerationHit{Stage: "wordlist", Rule: word, Action: action})
+			if action == moderationCensor {
+				censored = append(censored, span{start, end})
+			}
+		}
+		start = end
+	}
+	// censor from the end so earlier positions stay valid
+	for i := len(censored) - 1; i >= 0; i-- {
+		content.censor(censored[i].start, censored[i].end)
+	}
+}
+
+type regexRule struct {
+	name    string
+	action  string
+	pattern *regexp.Regexp
+}
+
+// regexStage applies regular expression rules to the normalized text.
+type regexStage struct {
+	rules func() []regexRule
+}
+
+// newRegexStage loads rules from path, one per line as
+// "<action> <name> <pattern>".
+func newRegexStage(path string) regexStage {
+	file := &watchedFile[[]regexRule]{
+		path:  path,
+		value: []regexRule{},
+		parse: func(lines []string) ([]regexRule, error) {
+			rules := []regexRule{}
+			for _, line := range lines {
+				fields := strings.SplitN(line, " ", 3)
+				if len(fields) != 3 {
+					return nil, fmt.Errorf("rule %q must be \"<action> <name> <pattern>\"", line)
+				}
+				action, err := parseAction(fields[0])
+				if err != nil {
+					return nil, err
+				}
+				pattern, err := regexp.Compile(strings.TrimSpace(fields[2]))
+				if err != nil {
+					return nil, fmt.Errorf("rule %s: %w", fields[1], err)
+				}
+				rules = append(rules, regexRule{name: fields[1], action: action, pattern: pattern})
+			}
+			return rules, nil
+		},
+	}
+	return regexStage{rules: file.get}
+}
+
+// Filter matches each rule against the normalized text, then against the
+// original text, since folding digits and symbols can hide what a rule is
+// looking for, such as a phone number.
+func (s regexStage) Filter(content *moderatedContent) {
+	for _, rule := range s.rules() {
+		text := content.Normalized
+		matches := rule.pattern.FindAllStringIndex(text, -1)
+		if len(matches) == 0 {
+			text = content.Text
+			matches = rule.pattern.FindAllStringIndex(text, -1)
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		content.Hits = append(content.Hits, database.ModerationHit{Stage: "regex", Rule: rule.name, Action: rule.action})
+		if rule.action != moderationCensor {
+			continue
+		}
+		for i := len(matches) - 1; i >= 0; i-- {
+			start := utf8.RuneCountInString(text[:matches[i][0]])
+			end := start + utf8.RuneCountInString(text[matches[i][0]:matches[i][1]])
+			content.censor(start, end)
+		}
+	}
+}
+
+// spamStage scores chirps on signs of spam: links, shouting, long runs of a
+// repeated character and words said over and over. Emails aren't scored.
+type spamStage struct {
+	flagScore   int
+	rejectScore int
+}
+
+func (s spamStage) score(text string) (int, []string) {
+	score := 0
+	signals := []string{}
+	if links := len(database.LinkPattern.FindAllString(text, -1)); links > 0 {
+		score += 2 * links
+		signals = append(signals, "links")
+	}
+
+	letters, upper := 0, 0
+	run, longestRun := 0, 0
+	var last rune
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+		if unicode.IsSpace(r) {
+			run = 0
+		} else if r == last {
+			run++
+		} else {
+			run = 1
+			last = r
+		}
+		if run > longestRun {
+			longestRun = run
+		}
+	}
+	if letters >= 20 && upper*10 >= letters*7 {
+		score += 2
+		signals = append(signals, "caps")
+	}
+	if longestRun >= 6 {
+		score++
+		signals = append(signals, "repeated characters")
+	}
+
+	counts := map[string]int{}
+	words := strings.Fields(strings.ToLower(text))
+	for _, word := range words {
+		counts[word]++
+	}
+	if len(words) >= 6 && len(counts)*2 <= len(words) {
+		score += 2
+		signals = append(signals, "repeated words")
+	}
+	return score, signals
+}
+
+func (s spamStage) Filter(content *moderatedContent) {
+	if content.Kind != contentChirp {
+		return
+	}
+	score, signals := s.score(content.Text)
+	rule := fmt.Sprintf("score %d (%s)", score, strings.Join(signals, ", "))
+	if score >= s.rejectScore {
+		content.Hits = append(content.Hits, database.ModerationHit{Stage: "spam", Rule: rule, Action: moderationReject})
+	} else if score >= s.flagScore {
+		content.Hits = append(content.Hits, database.ModerationHit{Stage: "spam", Rule: rule, Action: moderationFlag})
+	}
+}
+
+func envInt(name string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// newContentFilterFromEnv builds the moderation pipeline. MODERATION_WORDLIST
+// and MODERATION_RULES name the word list and regex rule files, which are
+// reloaded when they change.
+func newContentFilterFromEnv() ContentFilter {
+	pipeline := moderationPipeline{
+		normalizeStage{},
+		newWordListStage(os.Getenv("MODERATION_WORDLIST")),
+	}
+	if path := os.Getenv("MODERATION_RULES"); path != "" {
+		pipeline = append(pipeline, newRegexStage(path))
+	}
+	pipeline = append(pipeline, spamStage{
+		flagScore:   envInt("SPAM_FLAG_SCORE", 3),
+		rejectScore: envInt("SPAM_REJECT_SCORE", 6),
+	})
+	return pipeline
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestModerationPipeline(t *testing.T) {
+	dir := t.TempDir()
+	wordList := filepath.Join(dir, "words.txt")
+	rules := filepath.Join(dir, "rules.txt")
+	err := os.WriteFile(wordList, []byte("# test words\nkerfuffle\nscam reject\ncrypto flag\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(rules, []byte("reject phone \\d{3}-\\d{4}\ncensor shout !{3,}\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &apiConfig{contentFilter: moderationPipeline{
+		normalizeStage{},
+		newWordListStage(wordList),
+		newRegexStage(rules),
+		spamStage{flagScore: 3, rejectScore: 6},
+	}}
+
+	type hit struct{ stage, action string }
+	tests := []struct {
+		name     string
+		kind     string
+		text     string
+		wantText string
+		wantHits []hit
+	}{
+		{name: "clean", kind: contentChirp, text: "hello there", wantText: "hello there"},
+		{name: "censored word", kind: contentChirp, text: "what a Kerfuffle, really", wantText: "what a ****, really", wantHits: []hit{{"wordlist", "censor"}}},
+		{name: "look-alike letters", kind: contentChirp, text: "a kеrfufflе again", wantText: "a **** again", wantHits: []hit{{"wordlist", "censor"}}},
+		{name: "fullwidth letters", kind: contentChirp, text: "ｋｅｒｆｕｆｆｌｅ", wantText: "****", wantHits: []hit{{"wordlist", "censor"}}},
+		{name: "word inside another word", kind: contentChirp, text: "kerfuffles happen", wantText: "kerfuffles happen"},
+		{name: "rejected word", kind: contentChirp, text: "this is a scam", wantText: "this is a scam", wantHits: []hit{{"wordlist", "reject"}}},
+		{name: "flagged word", kind: contentChirp, text: "buy crypto today", wantText: "buy crypto today", wantHits: []hit{{"wordlist", "flag"}}},
+		{name: "regex on original text", kind: contentChirp, text: "call 555-1234", wantText: "call 555-1234", wantHits: []hit{{"regex", "reject"}}},
+		{name: "regex censor", kind: contentChirp, text: "wow!!!!", wantText: "wow****", wantHits: []hit{{"regex", "censor"}}},
+		{name: "first reject stops the pipeline", kind: contentChirp, text: "scam 555-1234", wantText: "scam 555-1234", wantHits: []hit{{"wordlist", "reject"}}},
+		{name: "spam flagged", kind: contentChirp, text: "see http://a.io and http://b.io", wantText: "see http://a.io and http://b.io", wantHits: []hit{{"spam", "flag"}}},
+		{name: "spam rejected", kind: contentChirp, text: "http://a.io http://b.io http://c.io", wantText: "http://a.io http://b.io http://c.io", wantHits: []hit{{"spam", "reject"}}},
+		{name: "messages aren't scored for spam", kind: contentMessage, text: "http://a.io http://b.io http://c.io", wantText: "http://a.io http://b.io http://c.io"},
+		{name: "profiles go through the word list", kind: contentProfile, text: "kerfuffle fan", wantText: "**** fan", wantHits: []hit{{"wordlist", "censor"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := c.moderate(tt.kind, tt.text)
+			if content.Text != tt.wantText {
+				t.Errorf("text = %q, want %q", content.Text, tt.wantText)
+			}
+			hits := []hit{}
+			for _, h := range content.Hits {
+				hits = append(hits, hit{h.Stage, h.Action})
+			}
+			if len(hits) != len(tt.wantHits) || (len(hits) > 0 && !reflect.DeepEqual(hits, tt.wantHits)) {
+				t.Errorf("hits = %v, want %v", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestModerateEmail(t *testing.T) {
+	c := &apiConfig{contentFilter: moderationPipeline{normalizeStage{}, newWordListStage("")}}
+	tests := []struct {
+		email   string
+		refused bool
+	}{
+		{"alice@example.com", false},
+		// emails can't be censored, so a censoring rule refuses them
+		{"kerfuffle@example.com", true},
+		{"KERFUFFLE@example.com", true},
+	}
+	for _, tt := range tests {
+		if _, refused := c.moderateEmail(tt.email); refused != tt.refused {
+			t.Errorf("moderateEmail(%q) refused = %v, want %v", tt.email, refused, tt.refused)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"internal/database"
+	"log"
+	"os"
+)
+
+// Notifier delivers account notifications, such as new-device alerts, to a
+// user.
+type Notifier interface {
+	Notify(user database.User, subject, message string) error
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(user database.User, subject, message string) error {
+	log.Printf("Notification for user %d: %s\n%s", user.ID, subject, message)
+	return nil
+}
+
+// mailNotifier emails notifications to the user's address.
+type mailNotifier struct {
+	mailer Mailer
+}
+
+func (n mailNotifier) Notify(user database.User, subject, message string) error {
+	return n.mailer.Send(user.Email, subject, message)
+}
+
+func newNotifierFromEnv(mailer Mailer) Notifier {
+	if os.Getenv("NOTIFIER") == "log" {
+		return logNotifier{}
+	}
+	return mailNotifier{mailer: mailer}
+}
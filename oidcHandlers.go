@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"internal/database"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+func (c *apiConfig) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if c.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		log.Printf("Error generating state %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		log.Printf("Error generating nonce %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		log.Printf("Error generating code verifier %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+
+	authURL, err := c.oidc.authCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("Error building authorization url %s", err)
+		respondWithError(w, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+	err = c.DB.SaveOIDCLogin(state, database.OIDCLogin{
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(oidcLoginTTL).Unix(),
+	})
+	if err != nil {
+		log.Printf("Error saving login state %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (c *apiConfig) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if c.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("Identity provider returned error %s: %s", providerErr, query.Get("error_description"))
+		respondWithError(w, http.StatusUnauthorized, "Login was not completed")
+		return
+	}
+	code := query.Get("code")
+	state := query.Get("state")
+	if code == "" || state == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing code or state")
+		return
+	}
+
+	login, err := c.DB.TakeOIDCLogin(state)
+	if err != nil {
+		if err.Error() == "login state not found" {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired login state")
+			return
+		}
+		log.Printf("Error getting login state %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error completing login")
+		return
+	}
+
+	rawIDToken, err := c.oidc.exchange(code, login.Verifier)
+	if err != nil {
+		log.Printf("Error exchanging code %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Error exchanging authorization code")
+		return
+	}
+	idToken, err := c.oidc.verifyIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("Error verifying id token %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Invalid id token")
+		return
+	}
+
+	user, err := c.findOrCreateOIDCUser(idToken)
+	if err != nil {
+		switch err.Error() {
+		case "email not verified":
+			respondWithError(w, http.StatusForbidden, "The identity provider has not verified this email address")
+		case "email required":
+			respondWithError(w, http.StatusBadRequest, "The identity provider did not share an email address")
+		default:
+			log.Printf("Error linking identity %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error linking identity")
+		}
+		return
+	}
+
+	c.respondWithLogin(w, r, user, "oidc")
+}
+
+// findOrCreateOIDCUser resolves the user for an ID token: an already linked
+// identity wins, then an existing user with the same verified email, and
+// otherwise a new passwordless user is created. Without a linked identity
+// the provider must have verified the email.
+func (c *apiConfig) findOrCreateOIDCUser(idToken oidcIDToken) (database.User, error) {
+	identity, err := c.DB.GetIdentity(c.oidc.issuer, idToken.Subject)
+	if err == nil {
+		return c.DB.GetUser(strconv.Itoa(identity.UserID))
+	}
+	if err.Error() != "identity not found" {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" {
+		return database.User{}, errors.New("email required")
+	}
+	// an unverified email could belong to anyone, so it must neither claim an
+	// existing account nor reserve the address for a new one
+	if !idToken.EmailVerified {
+		return database.User{}, errors.New("email not verified")
+	}
+	user, err := c.DB.GetUserByEmail(idToken.Email)
+	if err != nil {
+		if err.Error() != "user not found" {
+			return database.User{}, err
+		}
+		user, err = c.DB.CreateUser(idToken.Email, "")
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	_, err = c.DB.LinkIdentity(user.ID, c.oidc.issuer, idToken.Subject, idToken.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	return c.DB.GetUser(strconv.Itoa(user.ID))
+}
+
+func (c *apiConfig) handleGetIdentities(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identities, err := c.DB.GetUserIdentities(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting identities %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting identities")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, identities)
+}
+
+func (c *apiConfig) handleDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	err = c.DB.DeleteIdentity(id, tokenClaims.Id)
+	if err != nil {
+		if err.Error() == "identity not found" {
+			respondWithError(w, http.StatusNotFound, "Identity not found")
+			return
+		}
+		if err.Error() == "last login method" {
+			respondWithError(w, http.StatusConflict, "Cannot remove the only way to log in to this account")
+			return
+		}
+		log.Printf("Error deleting identity %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting identity")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Identity removed")
+}
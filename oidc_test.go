@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"internal/database"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider. Its authorization
+// endpoint signs in whoever is set as the next identity straight away.
+type mockOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	next  jwt.MapClaims
+	codes map[string]mockOIDCCode
+}
+
+type mockOIDCCode struct {
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	m := &mockOIDCProvider{key: key, clientID: "chirpy", codes: map[string]mockOIDCCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := randomToken(8)
+		m.mu.Lock()
+		claims := jwt.MapClaims{}
+		for k, v := range m.next {
+			claims[k] = v
+		}
+		claims["nonce"] = q.Get("nonce")
+		m.codes[code] = mockOIDCCode{claims: claims, challenge: q.Get("code_challenge")}
+		m.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		issued, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok || pkceChallenge(r.FormValue("code_verifier")) != issued.challenge {
+			respondWithError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		issued.claims["iss"] = m.server.URL
+		issued.claims["aud"] = m.clientID
+		issued.claims["exp"] = time.Now().Add(time.Minute).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, issued.claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(m.key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"id_token": signed})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// login runs the whole authorization code flow for claims and returns the
+// callback's response.
+func (m *mockOIDCProvider) login(t *testing.T, c *apiConfig, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+	m.mu.Lock()
+	m.next = claims
+	m.mu.Unlock()
+
+	start := httptest.NewRecorder()
+	c.handleOIDCLogin(start, httptest.NewRequest(http.MethodGet, "/api/login/oidc", nil))
+	if start.Code != http.StatusFound {
+		t.Fatalf("login start: status %d: %s", start.Code, start.Body)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(start.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("callback url: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	c.handleOIDCCallback(rec, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil))
+	return rec
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		claims   jwt.MapClaims
+		// wantStatus is the callback's status; wantExisting means the
+		// login must land on the existing user
+		wantStatus   int
+		wantExisting bool
+		wantUsers    int
+	}{
+		{
+			name:       "verified email creates a user",
+			claims:     jwt.MapClaims{"sub": "1", "email": "new@example.com", "email_verified": true},
+			wantStatus: http.StatusOK,
+			wantUsers:  1,
+		},
+		{
+			name:       "unverified email can't create a user",
+			claims:     jwt.MapClaims{"sub": "1", "email": "new@example.com", "email_verified": false},
+			wantStatus: http.StatusForbidden,
+			wantUsers:  0,
+		},
+		{
+			name:       "unverified email can't link an existing user",
+			existing:   "victim@example.com",
+			claims:     jwt.MapClaims{"sub": "1", "email": "victim@example.com"},
+			wantStatus: http.StatusForbidden,
+			wantUsers:  1,
+		},
+		{
+			name:         "verified email links an existing user ignoring case",
+			existing:     "Alice@Example.com",
+			claims:       jwt.MapClaims{"sub": "1", "email": "alice@example.COM", "email_verified": "true"},
+			wantStatus:   http.StatusOK,
+			wantExisting: true,
+			wantUsers:    1,
+		},
+		{
+			name:       "missing email is refused",
+			claims:     jwt.MapClaims{"sub": "1", "email_verified": true},
+			wantStatus: http.StatusBadRequest,
+			wantUsers:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			m := newMockOIDCProvider(t)
+			c.oidc = &oidcProvider{
+				issuer:      m.server.URL,
+				clientID:    m.clientID,
+				redirectURL: c.baseURL + "/api/login/oidc/callback",
+				client:      m.server.Client(),
+			}
+			existingID := 0
+			if tt.existing != "" {
+				hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+				user, err := c.DB.CreateUser(tt.existing, string(hash))
+				if err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+				existingID = user.ID
+			}
+
+			rec := m.login(t, c, tt.claims)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			page, err := c.DB.ListUsers(database.PageQuery{Limit: 10})
+			if err != nil {
+				t.Fatalf("ListUsers: %v", err)
+			}
+			if len(page.Users) != tt.wantUsers {
+				t.Errorf("%d users, want %d", len(page.Users), tt.wantUsers)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			body := loginResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if tt.wantExisting && body.Id != existingID {
+				t.Errorf("logged in as user %d, want %d", body.Id, existingID)
+			}
+			if body.Token == "" {
+				t.Error("no access token")
+			}
+
+			// the identity is linked now, so the same subject logs in again
+			// whatever email it reports
+			again := m.login(t, c, jwt.MapClaims{"sub": tt.claims["sub"], "email": "changed@example.com"})
+			if again.Code != http.StatusOK {
+				t.Fatalf("second login: status %d: %s", again.Code, again.Body)
+			}
+			if !strings.Contains(again.Body.String(), `"id":`+strconv.Itoa(body.Id)+`,`) {
+				t.Errorf("second login landed on another user: %s", again.Body)
+			}
+		})
+	}
+}
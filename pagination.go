@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageCursor is what's behind the opaque cursor strings handed to clients.
+type pageCursor struct {
+	ID     int   `json:"id"`
+	Key    int64 `json:"key"`
+	Before bool  `json:"before,omitempty"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	dat, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	cursor := pageCursor{}
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	err = json.Unmarshal(dat, &cursor)
+	if err != nil || cursor.ID <= 0 {
+		return pageCursor{}, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// parsePageQuery reads the cursor, limit and sort parameters shared by the
+// list endpoints.
+func parsePageQuery(r *http.Request) (database.PageQuery, error) {
+	query := r.URL.Query()
+	q := database.PageQuery{Limit: defaultPageLimit}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return q, fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		q.Limit = n
+	}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("sort must be asc or desc")
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		key := &database.PageKey{ID: cursor.ID, Key: cursor.Key}
+		if cursor.Before {
+			q.Before = key
+		} else {
+			q.After = key
+		}
+	}
+	return q, nil
+}
+
+// setPageHeaders advertises the neighbouring pages through X-Next-Cursor,
+// X-Prev-Cursor and a Link header, keeping the request's other parameters.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, next, prev *database.PageKey) {
+	links := []string{}
+	pageURL := func(cursor string) string {
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		return r.URL.Path + "?" + query.Encode()
+	}
+	if next != nil {
+		cursor := encodeCursor(pageCursor{ID: next.ID, Key: next.Key})
+		w.Header().Set("X-Next-Cursor", cursor)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(cursor)))
+	}
+	if prev != nil {
+		cursor := encodeCursor(pageCursor{ID: prev.ID, Key: prev.Key, Before: true})
+		w.Header().Set("X-Prev-Cursor", cursor)
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(cursor)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
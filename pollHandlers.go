@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type pollOptionResponse struct {
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+// pollResponse is a poll as seen by a particular viewer. Votes are left out
+// until the viewer has voted or the poll has closed, so early results don't
+// sway anyone.
+type pollResponse struct {
+	Options   []pollOptionResponse `json:"options"`
+	ClosesAt  time.Time            `json:"closes_at"`
+	Closed    bool                 `json:"closed"`
+	VoteCount *int                 `json:"vote_count,omitempty"`
+	MyVote    *int                 `json:"my_vote,omitempty"`
+}
+
+func presentPoll(poll *database.Poll, myVote *int) *pollResponse {
+	if poll == nil {
+		return nil
+	}
+	closed := poll.Closed(time.Now())
+	showResults := closed || myVote != nil
+	response := &pollResponse{
+		Options:  make([]pollOptionResponse, 0, len(poll.Options)),
+		ClosesAt: poll.ClosesAt,
+		Closed:   closed,
+		MyVote:   myVote,
+	}
+	if showResults {
+		voteCount := poll.VoteCount
+		response.VoteCount = &voteCount
+	}
+	for _, option := range poll.Options {
+		optionResponse := pollOptionResponse{Text: option.Text}
+		if showResults {
+			votes := option.Votes
+			optionResponse.Votes = &votes
+		}
+		response.Options = append(response.Options, optionResponse)
+	}
+	return response
+}
+
+// cleanPoll runs the options of a new poll through content moderation, like
+// the chirp they're part of. The moderated content is only returned along
+// with an error, for respondWithCleanChirpError.
+func (c *apiConfig) cleanPoll(poll *database.NewPoll) (*database.NewPoll, moderatedContent, error) {
+	if poll == nil {
+		return nil, moderatedContent{}, nil
+	}
+	cleaned := &database.NewPoll{ClosesAt: poll.ClosesAt, Options: []string{}}
+	for _, option := range poll.Options {
+		content := c.moderate(contentChirp, option)
+		if content.rejected() {
+			return nil, content, errors.New("chirp rejected")
+		}
+		cleaned.Options = append(cleaned.Options, content.Text)
+	}
+	return cleaned, moderatedContent{}, nil
+}
+
+// respondWithPollError reports why a chirp's poll was refused. It returns
+// false if err isn't about polls.
+func respondWithPollError(w http.ResponseWriter, err error) bool {
+	switch err.Error() {
+	case "invalid poll options":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A poll needs %d to %d different, non-empty options", database.MinPollOptions, database.MaxPollOptions))
+	case "poll option too long":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Poll options can be at most %d characters", database.MaxPollOptionLength))
+	case "invalid poll closing time":
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("closes_at must be in the future, at most %d days after the chirp is published", int(database.MaxPollDuration.Hours()/24)))
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *apiConfig) handleVotePoll(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Option *int `json:"option"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	if rBody.Option == nil {
+		respondWithError(w, http.StatusBadRequest, "option is required")
+		return
+	}
+
+	chirp, err := c.DB.VotePoll(id, tokenClaims.Id, *rBody.Option)
+	if err != nil {
+		switch err.Error() {
+		case "chirp not found":
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+		case "no poll":
+			respondWithError(w, http.StatusNotFound, "Chirp has no poll")
+		case "poll closed":
+			respondWithError(w, http.StatusConflict, "Poll has closed")
+		case "invalid option":
+			respondWithError(w, http.StatusBadRequest, "Invalid option")
+		default:
+			log.Printf("Error voting in poll %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error voting in poll")
+		}
+		return
+	}
+	c.respondWithChirp(w, http.StatusOK, tokenClaims.Id, chirp)
+}
+
+// sendPollResults tells the authors of polls that have closed how they
+// came out.
+func (c *apiConfig) sendPollResults() {
+	chirps, err := c.DB.CloseDuePolls(time.Now())
+	if err != nil {
+		log.Printf("Error closing polls %s", err)
+		return
+	}
+	for _, chirp := range chirps {
+		user, err := c.DB.GetUser(strconv.Itoa(chirp.Author))
+		if err != nil {
+			log.Printf("Error getting user to notify %s", err)
+			continue
+		}
+		err = c.notifier.Notify(user, "Your poll has closed", pollResultsMessage(c.baseURL, chirp))
+		if err != nil {
+			log.Printf("Error sending poll results %s", err)
+		}
+	}
+}
+
+func pollResultsMessage(baseURL string, chirp database.Chirp) string {
+	poll := chirp.Poll
+	lines := []string{fmt.Sprintf("Your poll has closed with %d votes. Final results:", poll.VoteCount)}
+	for _, option := range poll.Options {
+		percent := 0
+		if poll.VoteCount > 0 {
+			percent = option.Votes * 100 / poll.VoteCount
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %d votes (%d%%)", option.Text, option.Votes, percent))
+	}
+	lines = append(lines, fmt.Sprintf("See the chirp at %s/api/chirps/%d", baseURL, chirp.ID))
+	return strings.Join(lines, "\n")
+}
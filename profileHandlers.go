@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+)
+
+// profileResponse is a user's public profile. It never includes their email.
+type profileResponse struct {
+	ID             int             `json:"id"`
+	Handle         string          `json:"handle"`
+	DisplayName    string          `json:"display_name"`
+	Bio            string          `json:"bio"`
+	Website        string          `json:"website"`
+	Location       string          `json:"location"`
+	Avatar         *mediaResponse  `json:"avatar"`
+	IsChirpyRed    bool            `json:"is_chirpy_red"`
+	FollowerCount  int             `json:"followers"`
+	FollowingCount int             `json:"following"`
+	ChirpCount     int             `json:"chirps"`
+	PinnedChirps   []chirpResponse `json:"pinned_chirps"`
+}
+
+func (c *apiConfig) presentProfile(viewerID int, profile database.Profile) (profileResponse, error) {
+	pinned, err := c.presentChirps(viewerID, profile.PinnedChirps)
+	if err != nil {
+		return profileResponse{}, err
+	}
+	user := profile.User
+	response := profileResponse{
+		ID:             user.ID,
+		Handle:         user.Handle,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		Website:        user.Website,
+		Location:       user.Location,
+		IsChirpyRed:    user.IsChirpyRed,
+		FollowerCount:  profile.Counts.Followers,
+		FollowingCount: profile.Counts.Following,
+		ChirpCount:     profile.ChirpCount,
+		PinnedChirps:   pinned,
+	}
+	if user.AvatarMediaID != 0 {
+		media, err := c.DB.GetMedia(user.AvatarMediaID, viewerID)
+		if err != nil {
+			return profileResponse{}, err
+		}
+		avatar := c.presentMedia(media)
+		response.Avatar = &avatar
+	}
+	return response, nil
+}
+
+// presentUsers turns a page of users into their public profiles, as viewerID
+// sees them.
+func (c *apiConfig) presentUsers(viewerID int, users []database.User) ([]profileResponse, error) {
+	profiles, err := c.DB.GetProfiles(users, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]profileResponse, 0, len(profiles))
+	for _, profile := range profiles {
+		response, err := c.presentProfile(viewerID, profile)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// handlePutProfile replaces the authenticated user's public profile, so
+// fields left out are cleared. Display name, bio and location go through
+// content moderation like chirps do.
+func (c *apiConfig) handlePutProfile(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Handle        string `json:"handle"`
+		DisplayName   string `json:"display_name"`
+		Bio           string `json:"bio"`
+		Website       string `json:"website"`
+		Location      string `json:"location"`
+		AvatarMediaID int    `json:"avatar_media_id"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+
+	update := database.ProfileUpdate{
+		Handle:        rBody.Handle,
+		Website:       rBody.Website,
+		AvatarMediaID: rBody.AvatarMediaID,
+	}
+	fields := []struct {
+		name string
+		text string
+		dest *string
+	}{
+		{"display_name", rBody.DisplayName, &update.DisplayName},
+		{"bio", rBody.Bio, &update.Bio},
+		{"location", rBody.Location, &update.Location},
+	}
+	for _, field := range fields {
+		content := c.moderate(contentProfile, field.text)
+		if content.rejected() {
+			hit := content.firstHit(moderationReject)
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s rejected by %s rule %q", field.name, hit.Stage, hit.Rule))
+			return
+		}
+		*field.dest = content.Text
+	}
+
+	_, err = c.DB.UpdateProfile(tokenClaims.Id, update)
+	if err != nil {
+		switch err.Error() {
+		case "invalid handle":
+			respondWithError(w, http.StatusBadRequest, "Handles must be 1 to 15 letters, digits or underscores, and not only digits")
+		case "reserved handle":
+			respondWithError(w, http.StatusBadRequest, "This handle is reserved")
+		case "handle taken":
+			respondWithError(w, http.StatusConflict, "This handle is already taken")
+		case "display name too long":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Display names can be at most %d characters", database.MaxDisplayNameLength))
+		case "bio too long":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Bios can be at most %d characters", database.MaxBioLength))
+		case "location too long":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Locations can be at most %d characters", database.MaxLocationLength))
+		case "invalid website":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Websites must be http or https URLs of at most %d characters", database.MaxWebsiteLength))
+		case "media not found":
+			respondWithError(w, http.StatusBadRequest, "Avatar must be one of your uploads")
+		default:
+			log.Printf("Error updating profile %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating profile")
+		}
+		return
+	}
+	c.respondWithProfile(w, tokenClaims.Id, fmt.Sprint(tokenClaims.Id))
+}
+
+// respondWithProfile responds with the profile of the user ref names, by ID
+// or by handle, as viewerID sees it.
+func (c *apiConfig) respondWithProfile(w http.ResponseWriter, viewerID int, ref string) {
+	profile, err := c.DB.GetProfile(ref, viewerID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		log.Printf("Error getting profile %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		return
+	}
+	response, err := c.presentProfile(viewerID, profile)
+	if err != nil {
+		log.Printf("Error presenting profile %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (c *apiConfig) handlePostReport(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		ChirpId  int    `json:"chirp_id"`
+		UserId   int    `json:"user_id"`
+		Category string `json:"category"`
+		Comment  string `json:"comment"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	if (rBody.ChirpId == 0) == (rBody.UserId == 0) {
+		respondWithError(w, http.StatusBadRequest, "Exactly one of chirp_id and user_id is required")
+		return
+	}
+
+	report, err := c.DB.CreateReport(tokenClaims.Id, rBody.ChirpId, rBody.UserId, rBody.Category, rBody.Comment)
+	if err != nil {
+		switch err.Error() {
+		case "unknown report category":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown report category %q", rBody.Category))
+		case "chirp not found":
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+		case "user not found":
+			respondWithError(w, http.StatusNotFound, "User not found")
+		case "cannot report yourself":
+			respondWithError(w, http.StatusBadRequest, "Cannot report yourself")
+		case "already reported":
+			respondWithError(w, http.StatusConflict, "Already reported")
+		default:
+			log.Printf("Error creating report %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error creating report")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+func (c *apiConfig) handleGetMyModerationCases(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	cases, err := c.DB.GetUserModerationCases(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting moderation cases %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting moderation cases")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cases)
+}
+
+func (c *apiConfig) handleAppealModerationCase(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Reason string `json:"reason"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+	if rBody.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "Reason is required")
+		return
+	}
+
+	moderationCase, err := c.DB.AppealModerationCase(id, tokenClaims.Id, rBody.Reason)
+	if err != nil {
+		switch err.Error() {
+		case "case not found":
+			respondWithError(w, http.StatusNotFound, "Case not found")
+		case "nothing to appeal":
+			respondWithError(w, http.StatusConflict, "No action was taken in this case")
+		case "already appealed":
+			respondWithError(w, http.StatusConflict, "This case has already been appealed")
+		default:
+			log.Printf("Error appealing case %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error appealing case")
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, moderationCase)
+}
+
+// requireModerator authenticates the request and checks the user is one of
+// the moderators listed in MODERATOR_IDS. It writes the error response and
+// returns false otherwise.
+func (c *apiConfig) requireModerator(w http.ResponseWriter, r *http.Request) (int, bool) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return 0, false
+	}
+	if !c.moderators[tokenClaims.Id] {
+		respondWithError(w, http.StatusForbidden, "Moderators only")
+		return 0, false
+	}
+	return tokenClaims.Id, true
+}
+
+func (c *apiConfig) handleGetReportQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.requireModerator(w, r); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = database.CaseOpen
+	case database.CaseOpen, database.CaseAppealed, database.CaseClosed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be open, appealed or closed")
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListModerationCases(status, pageQuery)
+	if err != nil {
+		log.Printf("Error getting reports %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting reports")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, page.Cases)
+}
+
+func (c *apiConfig) handleGetReportCase(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.requireModerator(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	moderationCase, reports, err := c.DB.GetModerationCase(id)
+	if err != nil {
+		if err.Error() == "case not found" {
+			respondWithError(w, http.StatusNotFound, "Case not found")
+			return
+		}
+		log.Printf("Error getting case %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting case")
+		return
+	}
+	type returnBody struct {
+		database.ModerationCase
+		Reports []database.Report `json:"reports"`
+	}
+	respondWithJSON(w, http.StatusOK, returnBody{ModerationCase: moderationCase, Reports: reports})
+}
+
+func (c *apiConfig) handleDecideReportCase(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := c.requireModerator(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	defer r.Body.Close()
+	type requestBody struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading body %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading body")
+		return
+	}
+	rBody := requestBody{}
+	err = json.Unmarshal(dat, &rBody)
+	if err != nil {
+		log.Printf("Error unmarshalling JSON %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error unmarshalling JSON")
+		return
+	}
+
+	moderationCase, decision, err := c.DB.DecideModerationCase(id, moderatorID, rBody.Action, rBody.Note)
+	if err != nil {
+		switch err.Error() {
+		case "case not found":
+			respondWithError(w, http.StatusNotFound, "Case not found")
+		case "chirp not found":
+			respondWithError(w, http.StatusConflict, "The reported chirp no longer exists")
+		case "chirp id taken":
+			respondWithError(w, http.StatusConflict, "The removed chirp can no longer be restored")
+		case "invalid decision":
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Action %q can't be taken on this case", rBody.Action))
+		default:
+			log.Printf("Error deciding case %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deciding case")
+		}
+		return
+	}
+
+	c.notifyModerationDecision(moderationCase, decision)
+	type returnBody struct {
+		Case     database.ModerationCase     `json:"case"`
+		Decision database.ModerationDecision `json:"decision"`
+	}
+	respondWithJSON(w, http.StatusOK, returnBody{Case: moderationCase, Decision: decision})
+}
+
+// notifyModerationDecision tells the user a decision was taken against, or
+// the outcome of their appeal.
+func (c *apiConfig) notifyModerationDecision(moderationCase database.ModerationCase, decision database.ModerationDecision) {
+	var subject, message string
+	switch decision.Action {
+	case database.DecisionRemoveChirp:
+		subject = "Your chirp was removed"
+		message = fmt.Sprintf("A moderator removed your chirp %d after reviewing reports about it.", moderationCase.ChirpID)
+	case database.DecisionDisablePosting:
+		subject = "Posting disabled on your Chirpy account"
+		message = "A moderator disabled posting on your account after reviewing reports about it."
+	case database.DecisionUphold:
+		subject = "Your appeal was reviewed"
+		message = "A moderator reviewed your appeal and upheld the original decision."
+	case database.DecisionOverturn:
+		subject = "Your appeal was accepted"
+		message = "A moderator reviewed your appeal and reversed the original decision."
+	default:
+		return
+	}
+	if decision.Note != "" {
+		message += "\n\nModerator's note: " + decision.Note
+	}
+	if decision.Action == database.DecisionRemoveChirp || decision.Action == database.DecisionDisablePosting {
+		message += fmt.Sprintf("\n\nIf you think this was a mistake, you can appeal once at %s/api/users/me/moderation/%d/appeal", c.baseURL, moderationCase.ID)
+	}
+
+	user, err := c.DB.GetUser(strconv.Itoa(moderationCase.UserID))
+	if err != nil {
+		log.Printf("Error getting user to notify %s", err)
+		return
+	}
+	err = c.notifier.Notify(user, subject, message)
+	if err != nil {
+		log.Printf("Error sending moderation notice %s", err)
+	}
+}
+
+func (c *apiConfig) handleGetModerationAudit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.requireModerator(w, r); !ok {
+		return
+	}
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListModerationDecisions(pageQuery)
+	if err != nil {
+		log.Printf("Error getting audit trail %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting audit trail")
+		return
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, page.Decisions)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const defaultSchedulerInterval = 15 * time.Second
+
+// runScheduler publishes scheduled chirps as they come due and sends the
+// results of polls as they close, checking every interval. It checks once
+// straight away, so whatever came due while the server was down is handled
+// as soon as it starts again.
+func (c *apiConfig) runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.publishDueDrafts()
+		c.sendPollResults()
+		<-ticker.C
+	}
+}
+
+func (c *apiConfig) publishDueDrafts() {
+	chirps, failed, err := c.DB.PublishDueDrafts(time.Now())
+	if err != nil {
+		log.Printf("Error publishing scheduled chirps %s", err)
+		return
+	}
+	for _, chirp := range chirps {
+		log.Printf("Published scheduled chirp %d", chirp.ID)
+	}
+	for _, draft := range failed {
+		log.Printf("Error publishing draft %d %s", draft.ID, draft.Error)
+		user, err := c.DB.GetUser(strconv.Itoa(draft.Author))
+		if err != nil {
+			log.Printf("Error getting user to notify %s", err)
+			continue
+		}
+		message := fmt.Sprintf("Your scheduled chirp couldn't be published (%s). It has been kept as draft %d so you can fix it and publish or schedule it again.", draft.Error, draft.ID)
+		err = c.notifier.Notify(user, "Your scheduled chirp wasn't published", message)
+		if err != nil {
+			log.Printf("Error sending scheduled chirp notification %s", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"internal/database"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var searchQueryParams = map[string]bool{
+	"q":         true,
+	"author_id": true,
+	"since":     true,
+	"until":     true,
+	"rank":      true,
+	"cursor":    true,
+	"limit":     true,
+}
+
+func (c *apiConfig) handleSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	unknown := []string{}
+	for param := range query {
+		if !searchQueryParams[param] {
+			unknown = append(unknown, param)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown query parameter(s): %s", strings.Join(unknown, ", ")))
+		return
+	}
+
+	searchQuery := database.SearchQuery{Text: query.Get("q"), Rank: database.RankRelevance}
+	if strings.TrimSpace(searchQuery.Text) == "" {
+		respondWithError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	for _, value := range query["author_id"] {
+		for _, author := range strings.Split(value, ",") {
+			authorID, err := strconv.Atoi(strings.TrimSpace(author))
+			if err != nil || authorID < 1 {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("author_id must be a comma-separated list of user ids, got %q", value))
+				return
+			}
+			searchQuery.AuthorIDs = append(searchQuery.AuthorIDs, authorID)
+		}
+	}
+	var err error
+	searchQuery.Since, err = parseTimeParam(query, "since")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	searchQuery.Until, err = parseTimeParam(query, "until")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch rank := query.Get("rank"); rank {
+	case "":
+	case database.RankRelevance, database.RankRecency:
+		searchQuery.Rank = rank
+	default:
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("rank must be relevance or recency, got %q", rank))
+		return
+	}
+	searchQuery.Page, err = parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	searchQuery.ViewerID = c.optionalViewer(r)
+
+	page, err := c.DB.SearchChirps(searchQuery)
+	if err != nil {
+		if err.Error() == "empty search query" {
+			respondWithError(w, http.StatusBadRequest, "q has no searchable words")
+			return
+		}
+		log.Printf("Error searching chirps %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error searching chirps")
+		return
+	}
+
+	type searchResult struct {
+		chirpResponse
+		Score float64 `json:"score"`
+	}
+	chirps := make([]database.Chirp, 0, len(page.Results))
+	for _, result := range page.Results {
+		chirps = append(chirps, result.Chirp)
+	}
+	presented, err := c.presentChirps(searchQuery.ViewerID, chirps)
+	if err != nil {
+		log.Printf("Error searching chirps %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error searching chirps")
+		return
+	}
+	results := make([]searchResult, 0, len(presented))
+	for i, chirp := range presented {
+		results = append(results, searchResult{chirpResponse: chirp, Score: page.Results[i].Score})
+	}
+
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// handleRebuildSearchIndex rebuilds the search index from scratch. It rewrites
+// the whole database, so only moderators may trigger it.
+func (c *apiConfig) handleRebuildSearchIndex(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.requireModerator(w, r); !ok {
+		return
+	}
+	err := c.DB.RebuildSearchIndex()
+	if err != nil {
+		log.Printf("Error rebuilding search index %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error rebuilding search index")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "Search index rebuilt")
+}
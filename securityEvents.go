@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"internal/database"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// clientIP returns the address of the peer that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipRange groups addresses into the network a user is likely to stay in:
+// a /24 for IPv4 and a /48 for IPv6.
+func ipRange(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+func deviceFingerprint(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.UserAgent() + "|" + r.Header.Get("Accept-Language")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c *apiConfig) recordSecurityEvent(r *http.Request, userID int, eventType, method, outcome, reason string) {
+	_, err := c.DB.RecordSecurityEvent(database.SecurityEvent{
+		UserID:    userID,
+		Type:      eventType,
+		Method:    method,
+		Outcome:   outcome,
+		Reason:    reason,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("Error recording security event %s", err)
+	}
+}
+
+// checkNewDevice remembers the device a user just logged in from and sends a
+// notification when the device or network hasn't been seen for them before.
+func (c *apiConfig) checkNewDevice(r *http.Request, user database.User) {
+	ip := clientIP(r)
+	isNew, err := c.DB.RememberDevice(user.ID, deviceFingerprint(r), ipRange(ip))
+	if err != nil {
+		log.Printf("Error remembering device %s", err)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	message := fmt.Sprintf("We noticed a new login to your Chirpy account.\n\nTime: %s\nIP address: %s\nDevice: %s\n\nIf this wasn't you, change your password and revoke your sessions.",
+		time.Now().UTC().Format(time.RFC1123), ip, r.UserAgent())
+	go func() {
+		err := c.notifier.Notify(user, "New login to your Chirpy account", message)
+		if err != nil {
+			log.Printf("Error sending new device notification %s", err)
+		}
+	}()
+}
+
+func (c *apiConfig) handleGetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	tokenClaims, err := getAccessTokenData(r, c.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	events, err := c.DB.GetSecurityEvents(tokenClaims.Id)
+	if err != nil {
+		log.Printf("Error getting security events %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting security events")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
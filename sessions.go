@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessCookieName  = "chirpy_access"
+	refreshCookieName = "chirpy_refresh"
+	csrfCookieName    = "chirpy_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+// requestToken returns the bearer token from the Authorization header,
+// falling back to the named session cookie.
+func requestToken(r *http.Request, cookieName string) string {
+	header := r.Header.Get("Authorization")
+	if header != "" {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// setSessionCookies stores the tokens in HttpOnly cookies and issues a new
+// CSRF token. The CSRF cookie is readable by the frontend so it can echo it
+// back in the X-CSRF-Token header.
+func (c *apiConfig) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) (string, error) {
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(time.Hour.Seconds()),
+		HttpOnly: true,
+		Secure:   c.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if refreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    refreshToken,
+			Path:     "/api",
+			MaxAge:   int((time.Hour * 24 * 60).Seconds()),
+			HttpOnly: true,
+			Secure:   c.secureCookies,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int((time.Hour * 24 * 60).Seconds()),
+		Secure:   c.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+func (c *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{accessCookieName: "/", refreshCookieName: "/api", csrfCookieName: "/"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			MaxAge:   -1,
+			HttpOnly: name != csrfCookieName,
+			Secure:   c.secureCookies,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// middlewareCSRF enforces the double-submit check on state-changing requests
+// that authenticate with session cookies. Requests carrying an Authorization
+// header can't be forged cross-site, so they pass through.
+func middlewareCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, accessErr := r.Cookie(accessCookieName)
+		_, refreshErr := r.Cookie(refreshCookieName)
+		if accessErr != nil && refreshErr != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		csrfCookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(csrfCookie.Value)) != 1 {
+			respondWithError(w, http.StatusForbidden, "Invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"internal/database"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -21,6 +23,7 @@ func (c *apiConfig) handlePostUsers(w http.ResponseWriter, r *http.Request){
 		Id int `json:"id"`
 		Email string `json:"email"`
 		IsChirpyRed bool `json:"is_chirpy_red"`
+		Handle string `json:"handle"`
 	}
 	dat, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -43,6 +46,10 @@ func (c *apiConfig) handlePostUsers(w http.ResponseWriter, r *http.Request){
 		respondWithError(w, http.StatusBadRequest, "Email and password are required")
 		return
 	}
+	if hit, refused := c.moderateEmail(rBody.Email); refused {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Email rejected by %s rule %q", hit.Stage, hit.Rule))
+		return
+	}
 
 	// save to file database.json
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rBody.Password), bcrypt.DefaultCost)
@@ -64,31 +71,39 @@ func (c *apiConfig) handlePostUsers(w http.ResponseWriter, r *http.Request){
 		Id: user.ID,
 		Email: user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		Handle: user.Handle,
 	})
 }
 
 func (c *apiConfig) handleGetUsers(w http.ResponseWriter, r *http.Request){
-	users, err := c.DB.GetUsers()
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := c.DB.ListUsers(pageQuery)
 	if err != nil {
 		log.Printf("Error getting users %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Error getting users")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, users)
-}
-
-func (c *apiConfig) handleGetUser(w http.ResponseWriter, r *http.Request){
-	id := r.PathValue("id")
-
-	user, err := c.DB.GetUser(id)
+	users, err := c.presentUsers(c.optionalViewer(r), page.Users)
 	if err != nil {
-		log.Printf("Error getting user %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Error getting user")
+		log.Printf("Error presenting users %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Error getting users")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, user)
+	setPageHeaders(w, r, page.Next, page.Prev)
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// handleGetUser returns the public profile of the user in the path, which
+// can be an ID or a handle.
+func (c *apiConfig) handleGetUser(w http.ResponseWriter, r *http.Request){
+	c.respondWithProfile(w, c.optionalViewer(r), r.PathValue("id"))
 }
 
 
@@ -104,13 +119,6 @@ func (c *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request){
 		Email string `json:"email"`
 		Password string `json:"password"`
 	}
-	type returnBody struct {
-		Id int `json:"id"`
-		Email string `json:"email"`
-		IsChirpyRed bool `json:"is_chirpy_red"`
-		Token string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 	dat, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("Error reading body %s", err)
@@ -143,12 +151,24 @@ func (c *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request){
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(rBody.Password))
 	if err != nil {
 		log.Printf("Error comparing password %s", err)
+		c.recordSecurityEvent(r, user.ID, "login", "password", "failure", "invalid password")
 		respondWithError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
-	// create token
 
+	c.respondWithLogin(w, r, user, "password")
+}
+
+type loginResponse struct {
+	Id int `json:"id"`
+	Email string `json:"email"`
+	IsChirpyRed bool `json:"is_chirpy_red"`
+	Token string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken string `json:"csrf_token,omitempty"`
+}
 
+func (c *apiConfig) createTokenPair(user database.User) (string, string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, MyCustomClaims{
 		user.Email,
 		user.ID,
@@ -168,15 +188,46 @@ func (c *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request){
 	})
 
 	tokenString, err := token.SignedString([]byte(c.jwtSecret))
+	if err != nil {
+		return "", "", err
+	}
 	refreshTokenString, err := refreshToken.SignedString([]byte(c.jwtSecret))
+	if err != nil {
+		return "", "", err
+	}
+	return tokenString, refreshTokenString, nil
+}
+
+// respondWithLogin issues a fresh access/refresh pair for user. Every way of
+// logging in ends here so clients always get the same response shape. In
+// session mode the tokens go into HttpOnly cookies instead of the body.
+func (c *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User, method string) {
+	tokenString, refreshTokenString, err := c.createTokenPair(user)
 	if err != nil {
 		log.Printf("Error signing token %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Error signing token")
 		return
 	}
+	c.recordSecurityEvent(r, user.ID, "login", method, "success", "")
+	c.checkNewDevice(r, user)
+
+	if c.sessionMode {
+		csrfToken, err := c.setSessionCookies(w, tokenString, refreshTokenString)
+		if err != nil {
+			log.Printf("Error setting session cookies %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error setting session cookies")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, loginResponse{
+			Id: user.ID,
+			Email: user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+			CSRFToken: csrfToken,
+		})
+		return
+	}
 
-	// respond with id and cleaned body
-	respondWithJSON(w, http.StatusOK, returnBody{
+	respondWithJSON(w, http.StatusOK, loginResponse{
 		Id: user.ID,
 		Email: user.Email,
 		IsChirpyRed: user.IsChirpyRed,
@@ -188,14 +239,12 @@ func (c *apiConfig) handleLogin(w http.ResponseWriter, r *http.Request){
 func (c *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request){
 	// get ID from jwt header Authorization
 
-	token := r.Header.Get("Authorization")
+	token := requestToken(r, accessCookieName)
 	if token == "" {
 		log.Printf("No token provided")
 		respondWithError(w, http.StatusUnauthorized, "No token provided")
 		return
 	}
-	// strip bearer
-	token = token[7:]
 	claims := &MyCustomClaims{}
 	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(c.jwtSecret), nil
@@ -213,6 +262,7 @@ func (c *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request){
 	if claims.Issuer == "chirpy-refresh"{
 		log.Printf("Trying to access with refreshToken")
 		respondWithError(w, http.StatusUnauthorized, "Token is not valid")
+		return
 	}
 	id := fmt.Sprint(claims.Id)
 	log.Printf("ID PUT: %s", id)
@@ -248,6 +298,10 @@ func (c *apiConfig) handlePutUser(w http.ResponseWriter, r *http.Request){
 		respondWithError(w, http.StatusBadRequest, "Email and password are required")
 		return
 	}
+	if hit, refused := c.moderateEmail(rBody.Email); refused {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Email rejected by %s rule %q", hit.Stage, hit.Rule))
+		return
+	}
 
 	// save to file database.json
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rBody.Password), bcrypt.DefaultCost)
@@ -274,17 +328,16 @@ func (c *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request){
 	// get ID from jwt header Authorization
 
 	type returnBody struct{
-		Token string `json:"token"`
+		Token string `json:"token,omitempty"`
+		CSRFToken string `json:"csrf_token,omitempty"`
 	}
 
-	token := r.Header.Get("Authorization")
+	token := requestToken(r, refreshCookieName)
 	if token == "" {
 		log.Printf("No token provided")
 		respondWithError(w, http.StatusUnauthorized, "No token provided")
 		return
 	}
-	// strip bearer
-	token = token[7:]
 	claims := &jwt.StandardClaims{}
 	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(c.jwtSecret), nil
@@ -302,6 +355,7 @@ func (c *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request){
 	if claims.Issuer != "chirpy-refresh"{
 		log.Printf("Trying to refresh token with accessToken")
 		respondWithError(w, http.StatusUnauthorized, "Token is not valid")
+		return
 	}
 
 	// check 
@@ -313,6 +367,9 @@ func (c *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request){
 	}
 	if revoked{
 		log.Printf("Token is revoked")
+		if userID, err := strconv.Atoi(claims.Subject); err == nil {
+			c.recordSecurityEvent(r, userID, "refresh", "", "failure", "token revoked")
+		}
 		respondWithError(w, http.StatusUnauthorized, "Token is revoked")
 		return
 	}
@@ -342,6 +399,23 @@ func (c *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
+	c.recordSecurityEvent(r, user.ID, "refresh", "", "success", "")
+
+	if c.sessionMode {
+		// the CSRF cookie is rotated too, and cross-origin frontends can't
+		// read it, so send the new token along like login does
+		csrfToken, err := c.setSessionCookies(w, tokenString, "")
+		if err != nil {
+			log.Printf("Error setting session cookies %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Error setting session cookies")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, returnBody{
+			CSRFToken: csrfToken,
+		})
+		return
+	}
+
 	// respond with id and cleaned body
 	respondWithJSON(w, http.StatusOK, returnBody{
 		Token: tokenString,
@@ -351,14 +425,12 @@ func (c *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request){
 func (c *apiConfig) handleRevokeToken(w http.ResponseWriter, r *http.Request){
 	// get ID from jwt header Authorization
 
-	token := r.Header.Get("Authorization")
+	token := requestToken(r, refreshCookieName)
 	if token == "" {
 		log.Printf("No token provided")
 		respondWithError(w, http.StatusUnauthorized, "No token provided")
 		return
 	}
-	// strip bearer
-	token = token[7:]
 	claims := &jwt.StandardClaims{}
 	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(c.jwtSecret), nil
@@ -376,6 +448,7 @@ func (c *apiConfig) handleRevokeToken(w http.ResponseWriter, r *http.Request){
 	if claims.Issuer != "chirpy-refresh"{
 		log.Printf("Trying to refresh token with accessToken")
 		respondWithError(w, http.StatusUnauthorized, "Token is not valid")
+		return
 	}
 
 	// check 
@@ -390,5 +463,11 @@ func (c *apiConfig) handleRevokeToken(w http.ResponseWriter, r *http.Request){
 		respondWithError(w, http.StatusUnauthorized, "Token is not revoked")
 		return
 	}
+	if userID, err := strconv.Atoi(claims.Subject); err == nil {
+		c.recordSecurityEvent(r, userID, "revoke", "", "success", "")
+	}
+	if c.sessionMode {
+		c.clearSessionCookies(w)
+	}
 	respondWithJSON(w, http.StatusOK, "Token revoked")
 }
\ No newline at end of file
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserListsArePublic(t *testing.T) {
+	c := newTestConfig(t)
+	for i := 1; i <= 4; i++ {
+		if _, err := c.DB.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	if err := c.DB.Follow(2, 1); err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	if err := c.DB.Follow(3, 1); err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	if err := c.DB.Block(1, 4); err != nil {
+		t.Fatalf("Block: %v", err)
+	}
+	if err := c.DB.Mute(1, 3); err != nil {
+		t.Fatalf("Mute: %v", err)
+	}
+	list, err := c.DB.CreateList(database.List{OwnerID: 1, Name: "friends"})
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if _, err := c.DB.AddListMembers(list.ID, 1, []int{3}); err != nil {
+		t.Fatalf("AddListMembers: %v", err)
+	}
+	if _, err := c.DB.CreateChirp("hello", 3, database.ChirpOptions{}); err != nil {
+		t.Fatalf("CreateChirp: %v", err)
+	}
+	token, _, err := c.createTokenPair(database.User{ID: 1, Email: "user1@example.com"})
+	if err != nil {
+		t.Fatalf("createTokenPair: %v", err)
+	}
+	router := getApiRouter(c)
+
+	tests := []struct {
+		path    string
+		wantIDs []int
+	}{
+		{path: "/users", wantIDs: []int{1, 2, 3, 4}},
+		{path: "/users/1/followers", wantIDs: []int{2, 3}},
+		{path: "/users/2/following", wantIDs: []int{1}},
+		{path: "/users/me/blocks", wantIDs: []int{4}},
+		{path: "/users/me/mutes", wantIDs: []int{3}},
+		{path: fmt.Sprintf("/lists/%d/members", list.ID), wantIDs: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var users []map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&users); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			ids := map[int]bool{}
+			for _, user := range users {
+				for _, private := range []string{"email", "password", "allow_dms_from", "posting_disabled"} {
+					if _, ok := user[private]; ok {
+						t.Errorf("user %v has %s", user["id"], private)
+					}
+				}
+				if _, ok := user["handle"]; !ok {
+					t.Errorf("user %v has no handle", user["id"])
+				}
+				ids[int(user["id"].(float64))] = true
+				if user["id"].(float64) == 3 && user["chirps"].(float64) != 1 {
+					t.Errorf("user 3 has %v chirps, want 1", user["chirps"])
+				}
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			for _, id := range tt.wantIDs {
+				if !ids[id] {
+					t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}